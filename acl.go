@@ -0,0 +1,78 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// ACLUser is the ACL entity of a user or service account by email, e.g. to
+// GrantACL a service account storage.RoleReader.
+func ACLUser(email string) storage.ACLEntity {
+	return storage.ACLEntity("user-" + email)
+}
+
+// ACLGroup is the ACL entity of a Google group by email.
+func ACLGroup(email string) storage.ACLEntity {
+	return storage.ACLEntity("group-" + email)
+}
+
+// ACL returns the access control list of the object at key. Buckets with
+// uniform bucket-level access have no object ACLs and fail with
+// ErrUniformBucketLevelAccess.
+func (cs *CloudStorage) ACL(ctx context.Context, key string) (rules []storage.ACLRule, err error) {
+	defer cs.finish("ACL", key, &err)
+
+	rules, err = cs.bucket.Object(cs.Filename(key)).ACL().List(ctx)
+	if err2 := cs.wrapUniformAccessError(ctx, wrapStorageError(withDetails("ACL", key, err))); err2 != nil {
+		return nil, fmt.Errorf("ACL %s: %w", key, err2)
+	}
+	return rules, nil
+}
+
+// GrantACL gives entity role on the object at key, replacing any role the
+// entity had before. Granting allUsers or allAuthenticatedUsers fails with
+// ErrPublicAccessForbidden like MakePublic if public access is forbidden.
+func (cs *CloudStorage) GrantACL(ctx context.Context, key string, entity storage.ACLEntity, role storage.ACLRole) (err error) {
+	defer cs.finish("GrantACL", key, &err)
+
+	if cs.forbidpublic && isPublicEntity(entity) {
+		return fmt.Errorf("GrantACL %s: %w", key, ErrPublicAccessForbidden)
+	}
+	err = cs.bucket.Object(cs.Filename(key)).ACL().Set(ctx, entity, role)
+	if err2 := cs.wrapUniformAccessError(ctx, wrapStorageError(withDetails("GrantACL", key, err))); err2 != nil {
+		return fmt.Errorf("GrantACL %s: %w", key, err2)
+	}
+	return nil
+}
+
+// RevokeACL removes the role of entity on the object at key.
+func (cs *CloudStorage) RevokeACL(ctx context.Context, key string, entity storage.ACLEntity) (err error) {
+	defer cs.finish("RevokeACL", key, &err)
+
+	err = cs.bucket.Object(cs.Filename(key)).ACL().Delete(ctx, entity)
+	if err2 := cs.wrapUniformAccessError(ctx, wrapStorageError(withDetails("RevokeACL", key, err))); err2 != nil {
+		return fmt.Errorf("RevokeACL %s: %w", key, err2)
+	}
+	return nil
+}
+
+func isPublicEntity(entity storage.ACLEntity) bool {
+	return entity == storage.AllUsers || entity == storage.AllAuthenticatedUsers
+}
+
+// grantsPublicAccess reports whether update makes the object readable by
+// allUsers or allAuthenticatedUsers.
+func grantsPublicAccess(update storage.ObjectAttrsToUpdate) bool {
+	switch update.PredefinedACL {
+	case "publicRead", "publicReadWrite", "authenticatedRead":
+		return true
+	}
+	for _, rule := range update.ACL {
+		if isPublicEntity(rule.Entity) {
+			return true
+		}
+	}
+	return false
+}
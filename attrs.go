@@ -0,0 +1,54 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// UpdateAttrs changes the metadata of the object at key, such as content type,
+// cache control or custom metadata, without rewriting its payload. update is
+// given the current attributes and the change is only applied if the metadata
+// hasn't been changed by someone else in the meantime, failing with
+// ErrGenerationMismatch otherwise. Updates granting
+// public access fail with ErrPublicAccessForbidden if it is forbidden.
+func (cs *CloudStorage) UpdateAttrs(
+	ctx context.Context,
+	key string,
+	update func(*storage.ObjectAttrs) storage.ObjectAttrsToUpdate,
+) (_ *storage.ObjectAttrs, err error) {
+	defer cs.finish("UpdateAttrs", key, &err)
+
+	o := cs.bucket.Object(cs.Filename(key))
+	attrs, err := o.Attrs(ctx)
+	if err2 := wrapStorageError(withDetails("UpdateAttrs", key, err)); err2 != nil {
+		return nil, fmt.Errorf("UpdateAttrs %s: %w", key, err2)
+	}
+
+	toUpdate := update(attrs)
+	if cs.forbidpublic && grantsPublicAccess(toUpdate) {
+		return nil, fmt.Errorf("UpdateAttrs %s: %w", key, ErrPublicAccessForbidden)
+	}
+	conds := storage.Conditions{MetagenerationMatch: attrs.Metageneration}
+	updated, err := o.If(conds).Update(ctx, toUpdate)
+	if err2 := wrapWriteError(withDetails("UpdateAttrs", key, err), conds); err2 != nil {
+		return nil, fmt.Errorf("UpdateAttrs %s: %w", key, err2)
+	}
+	return updated, nil
+}
+
+// Touch sets the CustomTime of the object at key to now without rewriting its
+// payload, e.g. to keep recently used objects from expiring under a lifecycle
+// rule based on days since custom time.
+func (cs *CloudStorage) Touch(ctx context.Context, key string) (err error) {
+	defer cs.finish("Touch", key, &err)
+
+	o := cs.bucket.Object(cs.Filename(key))
+	_, err = o.Update(ctx, storage.ObjectAttrsToUpdate{CustomTime: time.Now()})
+	if err2 := wrapStorageError(err); err2 != nil {
+		return fmt.Errorf("Touch %s: %w", key, err2)
+	}
+	return nil
+}
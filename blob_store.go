@@ -0,0 +1,184 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+)
+
+// Blob is an untyped object payload.
+type Blob struct {
+	Data        []byte
+	ContentType string
+}
+
+// BlobStore defines an untyped Create, Get, Put, Delete datastore over a
+// CloudStorage for payloads that aren't JSON, such as PDFs and images.
+// The content type is given per call, an empty one is sniffed from the
+// payload. Since the CloudStorage filename format
+// is used for keys, blobs usually live in a CloudStorage created with
+// WithFilenameFormat("%s").
+// ErrObjectNotFound is returned if an operation is called on a non-existant object.
+type BlobStore interface {
+	Create(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(context.Context, string) (*Blob, error)
+	GetIfChanged(context.Context, string, int64) (*Blob, int64, error)
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Delete(context.Context, string) error
+	List(context.Context, string) *storage.ObjectIterator
+	ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator
+	ListGlob(context.Context, string) *storage.ObjectIterator
+	ListRange(ctx context.Context, start, end string) *storage.ObjectIterator
+
+	DeleteAll(context.Context, string, ProgressFunc) error
+}
+
+// blobStore implements the BlobStore interface.
+type blobStore struct {
+	cs    *CloudStorage
+	cfg   storeConfig
+	cache *generationCache[Blob]
+
+	readLimit  *bandwidthLimiter
+	writeLimit *bandwidthLimiter
+}
+
+func NewBlobStore(cs *CloudStorage, opts ...StoreOption) BlobStore {
+	cfg := newStoreConfig(opts)
+
+	b := &blobStore{
+		cs:         cs,
+		cfg:        cfg,
+		readLimit:  newBandwidthLimiter(cfg.readBandwidth),
+		writeLimit: newBandwidthLimiter(cfg.writeBandwidth),
+	}
+	if cfg.generationCache {
+		b.cache = newGenerationCache[Blob](cfg)
+	}
+	return b
+}
+
+// Create
+func (b *blobStore) Create(ctx context.Context, key string, r io.Reader, contentType string) error {
+	b.cache.evict(key)
+	r, err := b.cfg.limitSize(r)
+	if err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	r, settle, err := b.cfg.quotas.reserveWrite(key, r, nil)
+	if err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	err = b.cs.WriteFileAs(ctx, key, b.writeLimit.reader(ctx, r), contentType)
+	if settle(err); err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get
+func (b *blobStore) Get(ctx context.Context, key string) (*Blob, error) {
+	if b.cache != nil {
+		return b.cache.get(ctx, key, b.GetIfChanged)
+	}
+	blob, _, err := b.GetIfChanged(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// GetIfChanged only downloads the blob if its generation differs from the
+// known generation, returning ErrNotModified otherwise.
+func (b *blobStore) GetIfChanged(ctx context.Context, key string, generation int64) (*Blob, int64, error) {
+	release, err := b.cs.acquire(ctx)
+	if err != nil {
+		return nil, generation, fmt.Errorf("Get %s: %w", key, err)
+	}
+	defer release()
+
+	reader, err := b.cs.openIfChanged(ctx, key, generation)
+	if err != nil {
+		return nil, generation, fmt.Errorf("Get %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(b.readLimit.reader(ctx, reader))
+	if err != nil {
+		return nil, generation, fmt.Errorf("Get %s: readall: %w", key, err)
+	}
+	return &Blob{Data: data, ContentType: reader.Attrs.ContentType}, reader.Attrs.Generation, nil
+}
+
+// Put
+func (b *blobStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	b.cache.evict(key)
+	r, err := b.cfg.limitSize(r)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	if contentType == "" {
+		if r, contentType, err = sniffContentType(r); err != nil {
+			return fmt.Errorf("Put %s: %w", key, err)
+		}
+	}
+	previous, err := b.cfg.quotas.previous(ctx, key)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	var generation int64
+	if previous != nil {
+		generation = previous.Generation
+	}
+	r, settle, err := b.cfg.quotas.reserveWrite(key, r, previous)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	err = b.cs.putFile(ctx, key, b.writeLimit.reader(ctx, r), contentType, generation)
+	if settle(err); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete
+func (b *blobStore) Delete(ctx context.Context, key string) error {
+	b.cache.evict(key)
+	previous, err := b.cfg.quotas.previous(ctx, key)
+	if err != nil {
+		return fmt.Errorf("Delete %s: %w", key, err)
+	}
+	if err := b.cs.deleteFile(ctx, key); err != nil {
+		return b.cfg.deleteError(err)
+	}
+	b.cfg.quotas.releaseObject(key, previous)
+	return nil
+}
+
+// List
+func (b *blobStore) List(ctx context.Context, prefix string) *storage.ObjectIterator {
+	return b.cs.list(ctx, prefix, b.cfg.listAttrs...)
+}
+
+// ListDelimited
+func (b *blobStore) ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator {
+	return b.cs.listDelimited(ctx, prefix, delimiter, b.cfg.listAttrs...)
+}
+
+// ListGlob
+func (b *blobStore) ListGlob(ctx context.Context, glob string) *storage.ObjectIterator {
+	return b.cs.listGlob(ctx, glob, b.cfg.listAttrs...)
+}
+
+// ListRange
+func (b *blobStore) ListRange(ctx context.Context, start, end string) *storage.ObjectIterator {
+	return b.cs.listRange(ctx, start, end, b.cfg.listAttrs...)
+}
+
+// DeleteAll
+func (b *blobStore) DeleteAll(ctx context.Context, prefix string, progress ProgressFunc) error {
+	return deleteAll(ctx, b.cs, prefix, b.Delete, progress)
+}
@@ -0,0 +1,244 @@
+// Package bqexport streams the objects of a store into a BigQuery table, so
+// object data can be queried without ad-hoc ETL scripts.
+package bqexport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Columns added to every row next to the fields of the object.
+const (
+	ColumnKey        = "_key"
+	ColumnUpdated    = "_updated"
+	ColumnGeneration = "_generation"
+)
+
+// Options configures an Exporter.
+type Options struct {
+	// Schema is the schema of the fields of the objects.
+	// Defaults to the schema inferred from T with bigquery.InferSchema
+	Schema bigquery.Schema
+
+	// BatchSize is the number of rows inserted per request.
+	// Defaults to 500
+	BatchSize int
+
+	// Workers is the number of objects fetched concurrently while the
+	// prefix is listed.
+	// Defaults to 8
+	Workers int
+}
+
+// Exporter inserts the objects of a store into a BigQuery table, one row per
+// object with the fields of the object and the key, update time and
+// generation of the object.
+type Exporter[T any] struct {
+	cs     *objectstore.CloudStorage
+	store  objectstore.CRUDStore[T]
+	table  *bigquery.Table
+	schema bigquery.Schema
+	opts   Options
+}
+
+// New creates an Exporter inserting the objects of store into table. cs is
+// the CloudStorage of store, used to map the listed object names to keys.
+func New[T any](cs *objectstore.CloudStorage, store objectstore.CRUDStore[T], table *bigquery.Table, opts Options) (*Exporter[T], error) {
+	if opts.Schema == nil {
+		var obj T
+		schema, err := bigquery.InferSchema(obj)
+		if err != nil {
+			return nil, fmt.Errorf("bqexport: infer schema: %w", err)
+		}
+		opts.Schema = schema
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	schema := append(bigquery.Schema{
+		{Name: ColumnKey, Type: bigquery.StringFieldType, Required: true},
+		{Name: ColumnUpdated, Type: bigquery.TimestampFieldType, Required: true},
+		{Name: ColumnGeneration, Type: bigquery.IntegerFieldType, Required: true},
+	}, opts.Schema...)
+	return &Exporter[T]{cs: cs, store: store, table: table, schema: schema, opts: opts}, nil
+}
+
+// Schema returns the schema of the rows, the schema of the objects prefixed
+// by the key, update time and generation columns.
+func (e *Exporter[T]) Schema() bigquery.Schema { return e.schema }
+
+// EnsureTable creates the table with the schema of the rows if it doesn't
+// exist yet.
+func (e *Exporter[T]) EnsureTable(ctx context.Context) error {
+	err := e.table.Create(ctx, &bigquery.TableMetadata{Schema: e.schema})
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("bqexport: create table: %w", err)
+	}
+	return nil
+}
+
+// Export inserts all objects under prefix. Running it again inserts the
+// objects again, use ExportSince for incremental exports.
+func (e *Exporter[T]) Export(ctx context.Context, prefix string) (int, error) {
+	n, _, err := e.export(ctx, prefix, time.Time{})
+	return n, err
+}
+
+// ExportSince inserts the objects under prefix updated after since, and
+// returns the number of inserted rows and the latest update time among them,
+// to pass as since to the next run. Objects updated several times between
+// runs are only inserted in their latest version. On failure since is
+// returned, so the next run starts over.
+func (e *Exporter[T]) ExportSince(ctx context.Context, prefix string, since time.Time) (int, time.Time, error) {
+	return e.export(ctx, prefix, since)
+}
+
+func (e *Exporter[T]) export(ctx context.Context, prefix string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var failure error
+	fail := func(err error) {
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	// the listing is streamed to workers fetching the objects, which hand
+	// the rows to this goroutine inserting them in batches
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		it := e.store.List(ctx, prefix)
+		for {
+			attrs, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				return
+			} else if err != nil {
+				fail(err)
+				return
+			}
+			key, ok := e.cs.Key(attrs.Name)
+			if !ok || !attrs.Updated.After(since) {
+				continue
+			}
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	rows := make(chan *row)
+	var wg sync.WaitGroup
+	for w := 0; w < e.opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				entry, err := e.store.GetEntry(ctx, key)
+				if errors.Is(err, objectstore.ErrObjectNotFound) {
+					continue
+				} else if err != nil {
+					fail(err)
+					return
+				}
+				select {
+				case rows <- newRow(entry, e.opts.Schema):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(rows)
+	}()
+
+	inserter := e.table.Inserter()
+	latest := since
+	batch := make([]bigquery.ValueSaver, 0, e.opts.BatchSize)
+	inserted := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := inserter.Put(ctx, batch); err != nil {
+			fail(fmt.Errorf("insert: %w", err))
+			return
+		}
+		inserted += len(batch)
+		batch = batch[:0]
+	}
+	for r := range rows {
+		if ctx.Err() != nil {
+			// drain the workers after a failure
+			continue
+		}
+		batch = append(batch, r)
+		if r.updated.After(latest) {
+			latest = r.updated
+		}
+		if len(batch) == e.opts.BatchSize {
+			flush()
+		}
+	}
+	if ctx.Err() == nil {
+		flush()
+	}
+
+	if failure != nil {
+		return inserted, since, fmt.Errorf("bqexport %s: %w", prefix, failure)
+	} else if err := ctx.Err(); err != nil {
+		return inserted, since, fmt.Errorf("bqexport %s: %w", prefix, err)
+	}
+	return inserted, latest, nil
+}
+
+// row is an object with the columns describing it.
+type row struct {
+	key        string
+	updated    time.Time
+	generation int64
+	saver      bigquery.StructSaver
+}
+
+func newRow[T any](entry *objectstore.Entry[T], schema bigquery.Schema) *row {
+	return &row{
+		key:        entry.Key,
+		updated:    entry.Updated,
+		generation: entry.Generation,
+		saver:      bigquery.StructSaver{Schema: schema, Struct: entry.Value},
+	}
+}
+
+func (r *row) Save() (map[string]bigquery.Value, string, error) {
+	values, _, err := r.saver.Save()
+	if err != nil {
+		return nil, "", err
+	}
+	values[ColumnKey] = r.key
+	values[ColumnUpdated] = r.updated
+	values[ColumnGeneration] = r.generation
+	// deduplicates retried inserts of the same version on a best effort basis
+	return values, fmt.Sprintf("%s#%d", r.key, r.generation), nil
+}
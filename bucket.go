@@ -0,0 +1,104 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrBucketMisconfigured is returned by RequireBucket if the bucket doesn't
+// have the required configuration.
+var ErrBucketMisconfigured = errors.New("bucket misconfigured")
+
+// BucketInfo is the configuration of a bucket.
+type BucketInfo struct {
+	Name         string
+	Location     string
+	LocationType string
+	StorageClass string
+	Versioning   bool
+	Labels       map[string]string
+}
+
+// BucketRequirements is the configuration required by RequireBucket. Zero
+// fields aren't checked.
+type BucketRequirements struct {
+	// Versioning requires object versioning, e.g. for stores using GetAsOf.
+	Versioning   bool
+	Location     string
+	StorageClass string
+	// Labels must all be set to the given values, other labels are ignored.
+	Labels map[string]string
+}
+
+// BucketInfo returns the configuration of the bucket.
+func (cs *CloudStorage) BucketInfo(ctx context.Context) (BucketInfo, error) {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return BucketInfo{}, fmt.Errorf("BucketInfo: %w", err)
+	}
+	return BucketInfo{
+		Name:         attrs.Name,
+		Location:     attrs.Location,
+		LocationType: attrs.LocationType,
+		StorageClass: attrs.StorageClass,
+		Versioning:   attrs.VersioningEnabled,
+		Labels:       attrs.Labels,
+	}, nil
+}
+
+// RequireBucket fails with ErrBucketMisconfigured listing every requirement the
+// bucket doesn't meet, e.g. at startup to fail fast on misconfiguration.
+func (cs *CloudStorage) RequireBucket(ctx context.Context, req BucketRequirements) error {
+	info, err := cs.BucketInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("RequireBucket: %w", err)
+	}
+
+	var problems []string
+	if req.Versioning && !info.Versioning {
+		problems = append(problems, "versioning is disabled")
+	}
+	// locations and storage classes are reported in upper case
+	if req.Location != "" && !strings.EqualFold(req.Location, info.Location) {
+		problems = append(problems, fmt.Sprintf("location is %s, not %s", info.Location, req.Location))
+	}
+	if req.StorageClass != "" && !strings.EqualFold(req.StorageClass, info.StorageClass) {
+		problems = append(problems, fmt.Sprintf("storage class is %s, not %s", info.StorageClass, req.StorageClass))
+	}
+	labels := make([]string, 0, len(req.Labels))
+	for k := range req.Labels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		if got, v := info.Labels[k], req.Labels[k]; got != v {
+			problems = append(problems, fmt.Sprintf("label %s is %q, not %q", k, got, v))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("RequireBucket %s: %w: %s", info.Name, ErrBucketMisconfigured, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// SetBucketLabels sets the given labels on the bucket. Other labels are left
+// as is, empty values remove the label.
+func (cs *CloudStorage) SetBucketLabels(ctx context.Context, labels map[string]string) error {
+	var update storage.BucketAttrsToUpdate
+	for k, v := range labels {
+		if v == "" {
+			update.DeleteLabel(k)
+		} else {
+			update.SetLabel(k, v)
+		}
+	}
+	if _, err := cs.bucket.Update(ctx, update); err != nil {
+		return fmt.Errorf("SetBucketLabels: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ProgressFunc is called by bulk operations after each processed object.
+// total is the number of objects the operation will process and lastKey is
+// the key of the object that was just processed, which makes it usable as a
+// resume checkpoint.
+type ProgressFunc func(done, total int, lastKey string)
+
+// Entry is a decoded object together with its key and attributes. In bulk
+// operations the attributes are those listed before the object was fetched.
+type Entry[T any] struct {
+	Key        string
+	Value      *T
+	Created    time.Time
+	Updated    time.Time
+	Generation int64
+	Size       int64
+}
+
+// GetAll decodes every object under prefix. progress may be nil.
+// Objects are downloaded and decoded by WithConcurrency goroutines, see
+// WithOrderedResults for the order of the returned entries.
+func (q *querier[T]) GetAll(ctx context.Context, prefix string, progress ProgressFunc) (_ []Entry[T], err error) {
+	defer q.cs.finish("GetAll", prefix, &err)
+	return getAll(ctx, q.cs, prefix, q.Get, q.cfg, progress)
+}
+
+// getAll lists the keys under prefix and fetches them with get, using
+// cfg.concurrency workers.
+func getAll[T any](
+	ctx context.Context,
+	cs *CloudStorage,
+	prefix string,
+	get func(context.Context, string) (*T, error),
+	cfg storeConfig,
+	progress ProgressFunc,
+) ([]Entry[T], error) {
+	listed, err := listEntries[T](ctx, cs, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll %s: %w", prefix, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		i   int
+		obj *T
+		err error
+	}
+	indices := make(chan int)
+	results := make(chan result)
+
+	go func() {
+		defer close(indices)
+		for i := range listed {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				obj, err := get(ctx, listed[i].Key)
+				select {
+				case results <- result{i, obj, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	entries := make([]Entry[T], 0, len(listed))
+	if cfg.ordered {
+		entries = entries[:len(listed)]
+	}
+	done := 0
+	for r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("GetAll %s: %w", prefix, r.err)
+		}
+
+		entry := listed[r.i]
+		entry.Value = r.obj
+		if cfg.ordered {
+			entries[r.i] = entry
+		} else {
+			entries = append(entries, entry)
+		}
+
+		done++
+		if progress != nil {
+			progress(done, len(listed), entry.Key)
+		}
+	}
+	if err := ctx.Err(); err != nil && done < len(listed) {
+		return nil, fmt.Errorf("GetAll %s: %w", prefix, err)
+	}
+	return entries, nil
+}
+
+// Prefetch warms the generation cache with every object under prefix, so
+// subsequent Gets only issue a metadata request. It is a no-op unless the
+// store was created WithGenerationCache.
+func (q *querier[T]) Prefetch(ctx context.Context, prefix string) (err error) {
+	defer q.cs.finish("Prefetch", prefix, &err)
+
+	if q.cache == nil {
+		return nil
+	}
+	if _, err := getAll(ctx, q.cs, prefix, q.Get, q.cfg, nil); err != nil {
+		return fmt.Errorf("Prefetch %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// DeleteAll deletes every object under prefix. Objects that disappear while the
+// operation is running are not considered errors. progress may be nil.
+func (q *querier[T]) DeleteAll(ctx context.Context, prefix string, progress ProgressFunc) (err error) {
+	defer q.cs.finish("DeleteAll", prefix, &err)
+	return deleteAll(ctx, q.cs, prefix, q.Delete, progress)
+}
+
+// deleteAll lists the keys under prefix and deletes each one with del.
+func deleteAll(
+	ctx context.Context,
+	cs *CloudStorage,
+	prefix string,
+	del func(context.Context, string) error,
+	progress ProgressFunc,
+) error {
+	keys, err := cs.listKeys(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("DeleteAll %s: %w", prefix, err)
+	}
+
+	for i, key := range keys {
+		if err := del(ctx, key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return fmt.Errorf("DeleteAll %s: %w", prefix, err)
+		}
+		if progress != nil {
+			progress(i+1, len(keys), key)
+		}
+	}
+	return nil
+}
+
+// listKeys returns the keys of all objects under prefix, skipping objects that
+// don't match the filename format.
+func (cs *CloudStorage) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	it := cs.readAhead(ctx, cs.list(ctx, prefix, "Name"))
+
+	var keys []string
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if key, ok := cs.Key(attrs.Name); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// listEntries lists the objects under prefix as entries without values.
+func listEntries[T any](ctx context.Context, cs *CloudStorage, prefix string) ([]Entry[T], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	it := cs.readAhead(ctx, cs.list(ctx, prefix, "Name", "Created", "Updated", "Generation", "Size"))
+
+	var entries []Entry[T]
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if key, ok := cs.Key(attrs.Name); ok {
+			entries = append(entries, newEntry[T](key, nil, attrs))
+		}
+	}
+	return entries, nil
+}
+
+func newEntry[T any](key string, obj *T, attrs *storage.ObjectAttrs) Entry[T] {
+	return Entry[T]{
+		Key:        key,
+		Value:      obj,
+		Created:    attrs.Created,
+		Updated:    attrs.Updated,
+		Generation: attrs.Generation,
+		Size:       attrs.Size,
+	}
+}
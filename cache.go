@@ -0,0 +1,187 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// generationCache remembers decoded objects by key together with the
+// generation they were read at. A nil *generationCache is a no-op.
+type generationCache[T any] struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry[T]
+	refreshing map[string]bool
+	// evictions is bumped on every evict so fetches racing with a write
+	// don't cache what they read before it
+	evictions uint64
+
+	tolerance time.Duration
+	interval  time.Duration
+	onChange  func(key string, generation int64)
+	// timers refresh the cached keys every interval
+	timers map[string]*time.Timer
+}
+
+type cacheEntry[T any] struct {
+	generation int64
+	value      *T
+	checked    time.Time
+}
+
+type fetchFunc[T any] func(context.Context, string, int64) (*T, int64, error)
+
+// newGenerationCache creates a cache which serves entries checked less than
+// the stale tolerance ago without asking fetch, or always serves them and
+// refreshes them every interval WithBackgroundRefresh.
+func newGenerationCache[T any](cfg storeConfig) *generationCache[T] {
+	return &generationCache[T]{
+		entries:    make(map[string]cacheEntry[T]),
+		refreshing: make(map[string]bool),
+		tolerance:  cfg.staleTolerance,
+		interval:   cfg.refreshInterval,
+		onChange:   cfg.onNewGeneration,
+		timers:     make(map[string]*time.Timer),
+	}
+}
+
+// get returns the cached object for key if fetch reports it as not modified,
+// otherwise the freshly fetched object is cached and returned.
+//
+// Entries younger than the stale tolerance are returned without calling fetch.
+// Once they are past half the tolerance they are revalidated in the background
+// so frequently read keys rarely wait for fetch. With a refresh interval
+// entries are always returned without calling fetch, see schedule.
+func (c *generationCache[T]) get(ctx context.Context, key string, fetch fetchFunc[T]) (*T, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	evictions := c.evictions
+	if ok && c.interval > 0 {
+		c.mu.Unlock()
+		return copyOf(entry.value), nil
+	} else if ok && c.tolerance > 0 {
+		age := time.Since(entry.checked)
+		if age < c.tolerance {
+			if age >= c.tolerance/2 && !c.refreshing[key] {
+				c.refreshing[key] = true
+				go c.refresh(key, entry, fetch)
+			}
+			c.mu.Unlock()
+			return copyOf(entry.value), nil
+		}
+	}
+	c.mu.Unlock()
+
+	return c.fetch(ctx, key, entry, ok, evictions, fetch)
+}
+
+// refresh revalidates entry in the background.
+func (c *generationCache[T]) refresh(key string, entry cacheEntry[T], fetch fetchFunc[T]) {
+	c.mu.Lock()
+	evictions := c.evictions
+	c.mu.Unlock()
+
+	// errors are left for the next synchronous fetch to report
+	c.fetch(context.Background(), key, entry, true, evictions, fetch)
+
+	c.mu.Lock()
+	delete(c.refreshing, key)
+	c.mu.Unlock()
+}
+
+func (c *generationCache[T]) fetch(
+	ctx context.Context,
+	key string,
+	entry cacheEntry[T],
+	cached bool,
+	evictions uint64,
+	fetch fetchFunc[T],
+) (*T, error) {
+	obj, generation, err := fetch(ctx, key, entry.generation)
+	if cached && errors.Is(err, ErrNotModified) {
+		entry.checked = time.Now()
+		obj = entry.value
+	} else if errors.Is(err, ErrObjectNotFound) {
+		c.evict(key)
+		if cached {
+			c.changed(key, 0)
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	} else {
+		if cached && generation != entry.generation {
+			defer c.changed(key, generation)
+		}
+		entry = cacheEntry[T]{generation: generation, value: obj, checked: time.Now()}
+	}
+
+	c.mu.Lock()
+	if c.evictions == evictions {
+		c.entries[key] = entry
+		c.schedule(key, fetch)
+	}
+	c.mu.Unlock()
+
+	return copyOf(obj), nil
+}
+
+// changed reports a new generation of a cached key, 0 if it was deleted.
+func (c *generationCache[T]) changed(key string, generation int64) {
+	if c.onChange != nil {
+		c.onChange(key, generation)
+	}
+}
+
+// schedule refreshes key every interval for as long as it is cached, so
+// entries are kept fresh without Gets waiting for fetch. Must be called with
+// mu held.
+func (c *generationCache[T]) schedule(key string, fetch fetchFunc[T]) {
+	if c.interval <= 0 || c.timers[key] != nil {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(c.interval, func() {
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		evictions := c.evictions
+		c.mu.Unlock()
+
+		if ok {
+			// errors are left for the next refresh to retry
+			c.fetch(context.Background(), key, entry, true, evictions, fetch)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.timers[key] != timer {
+			return
+		} else if _, ok := c.entries[key]; !ok {
+			delete(c.timers, key)
+			return
+		}
+		timer.Reset(c.interval)
+	})
+	c.timers[key] = timer
+}
+
+func (c *generationCache[T]) evict(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, key)
+	if timer := c.timers[key]; timer != nil {
+		timer.Stop()
+		delete(c.timers, key)
+	}
+	c.evictions++
+	c.mu.Unlock()
+}
+
+// copyOf hands out shallow copies so callers can't mutate cached values.
+func copyOf[T any](v *T) *T {
+	c := *v
+	return &c
+}
@@ -0,0 +1,89 @@
+package objectstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded objects for the Cached decorator, e.g. in memory with
+// NewMemoryCache or in Redis with the rediscache package. Implementations
+// must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value stored for key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value for key, expiring it after ttl. A ttl <= 0 stores
+	// value without expiry, as Redis does.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// memoryCacheSweepInterval is how often memory caches drop the expired values
+// which were not read since they expired.
+const memoryCacheSweepInterval = time.Minute
+
+// NewMemoryCache creates a Cache holding values in process memory. Expired
+// values are dropped when they are next read, or by the sweep of expired
+// values which runs on Set at most once a minute.
+func NewMemoryCache() Cache {
+	return &memoryCache{entries: make(map[string]memoryCacheEntry), swept: time.Now()}
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	swept   time.Time
+}
+
+type memoryCacheEntry struct {
+	value []byte
+	// expires is zero for values without expiry
+	expires time.Time
+}
+
+func (e memoryCacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.expired(time.Now()) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	if now.Sub(c.swept) >= memoryCacheSweepInterval {
+		c.swept = now
+		for key, entry := range c.entries {
+			if entry.expired(now) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *memoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	return nil
+}
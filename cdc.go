@@ -0,0 +1,156 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Change is a change of an object decoded by a CDC consumer.
+type Change[T any] struct {
+	ChangeEvent
+	// Value is the object at Generation, nil for deletes.
+	Value *T
+}
+
+// CDC turns object change notifications into ordered, typed changes. For
+// every change it reads the generation of the notification, decodes it and
+// calls the handler, then records the generation in a checkpoint object of
+// the key in the bucket.
+//
+// Changes of a key are passed to the handler in generation order. Stale
+// notifications, for generations older than the checkpoint, are dropped, as
+// are notifications of generations which have already been replaced or
+// deleted, whose successor will be passed on its own. Delivery is at least
+// once: a handler error, or a crash before the checkpoint is written, leads
+// to the change being passed again when the notification is redelivered.
+type CDC[T any] struct {
+	cs          *CloudStorage
+	objects     *querier[T]
+	checkpoints *querier[cdcCheckpoint]
+	prefix      string
+	handler     func(context.Context, Change[T]) error
+	locks       *keyLocks
+}
+
+// cdcCheckpoint is the last change passed to the handler for a key.
+type cdcCheckpoint struct {
+	Generation int64 `json:"generation"`
+	Deleted    bool  `json:"deleted"`
+}
+
+// NewCDC creates a CDC consumer calling handler, with the checkpoints stored
+// under checkpointPrefix, e.g. `_cdc/orders/`. Objects are decoded like by a
+// store created with opts.
+func NewCDC[T any](cs *CloudStorage, checkpointPrefix string, handler func(context.Context, Change[T]) error, opts ...StoreOption) *CDC[T] {
+	return &CDC[T]{
+		cs:          cs,
+		objects:     newQuerier[T](cs, opts...),
+		checkpoints: newQuerier[cdcCheckpoint](cs),
+		prefix:      checkpointPrefix,
+		handler:     handler,
+		locks:       newKeyLocks(),
+	}
+}
+
+// ServeHTTP consumes the notifications delivered by Eventarc or a Pub/Sub
+// push subscription, in the formats of ChangeBridge. Errors are served as
+// 500 so the notification is redelivered.
+func (c *CDC[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	n, err := parseNotification(r)
+	if err != nil {
+		http.Error(w, "invalid notification: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n.bucket == c.cs.bucketname && !n.skip {
+		if err := c.Consume(r.Context(), c.cs.changeEvent(n.change, n.name, n.generation)); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Consume processes a single change notification, e.g. from Poll:
+//
+//	cs.Poll(ctx, prefix, time.Minute, func(event ChangeEvent) error {
+//		return cdc.Consume(ctx, event)
+//	})
+func (c *CDC[T]) Consume(ctx context.Context, event ChangeEvent) error {
+	if event.Key == "" || strings.HasPrefix(event.Key, c.prefix) {
+		return nil
+	}
+	defer c.locks.lock(event.Key)()
+
+	checkpoint, generation, err := c.checkpoint(ctx, event.Key)
+	if err != nil {
+		return fmt.Errorf("Consume %s: checkpoint: %w", event.Key, err)
+	}
+	deleted := event.Type == ObjectDeleted
+	if event.Generation < checkpoint.Generation ||
+		event.Generation == checkpoint.Generation && (checkpoint.Deleted || !deleted) {
+		return nil
+	}
+
+	change := Change[T]{ChangeEvent: event}
+	if deleted {
+		// the delete of a replaced generation isn't a delete of the key
+		attrs, err := c.cs.objectAttrs(ctx, event.Name)
+		if err == nil && attrs.Generation > event.Generation {
+			return nil
+		} else if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			// don't report a delete of a key which may still exist
+			return fmt.Errorf("Consume %s: %w", event.Key, withDetails("Consume", event.Key, err))
+		}
+	} else {
+		change.Value, err = c.objects.getGeneration(ctx, event.Key, event.Generation)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Consume %s: %w", event.Key, err)
+		}
+		change.Type = ObjectCreated
+		if checkpoint.Generation != 0 && !checkpoint.Deleted {
+			change.Type = ObjectUpdated
+		}
+	}
+
+	if err := c.handler(ctx, change); err != nil {
+		return fmt.Errorf("Consume %s: %w", event.Key, err)
+	}
+
+	data, err := c.cs.Marshal(cdcCheckpoint{Generation: event.Generation, Deleted: deleted})
+	if err != nil {
+		return fmt.Errorf("Consume %s: checkpoint: %w", event.Key, err)
+	}
+	conds := storage.Conditions{DoesNotExist: true}
+	if generation != 0 {
+		conds = storage.Conditions{GenerationMatch: generation}
+	}
+	if err := c.cs.writeFileIf(ctx, c.prefix+event.Key, bytes.NewReader(data), "application/json", conds); err != nil {
+		return fmt.Errorf("Consume %s: checkpoint: %w", event.Key, err)
+	}
+	return nil
+}
+
+// checkpoint reads the checkpoint of key together with the generation of the
+// checkpoint object, 0 if there is none yet.
+func (c *CDC[T]) checkpoint(ctx context.Context, key string) (cdcCheckpoint, int64, error) {
+	entry, err := c.checkpoints.GetEntry(ctx, c.prefix+key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return cdcCheckpoint{}, 0, nil
+	} else if err != nil {
+		return cdcCheckpoint{}, 0, err
+	}
+	return *entry.Value, entry.Generation, nil
+}
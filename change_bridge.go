@@ -0,0 +1,245 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// ChangeHandler is called by a ChangeBridge with the changed object, or nil
+// for deletes.
+type ChangeHandler[T any] func(ctx context.Context, event ChangeEvent, obj *T) error
+
+// ChangeBridge is the push counterpart of Poll. It serves the object change
+// notifications of the bucket, delivered by Eventarc as CloudEvents or by a
+// Pub/Sub push subscription of a bucket notification, maps the object names
+// to keys, decodes the objects and dispatches them to the handlers registered
+// for their keys.
+//
+// Handlers get the generation of the notification, not the live object, so
+// every write is seen even if the object changed again since. This requires
+// object versioning for generations which were replaced in the meantime, and
+// a store created by NewCRUDStore; other stores, e.g. decorated ones, only
+// dispatch the notification of the live generation.
+//
+// Handler errors are served as 500 so the notification is redelivered, so
+// handlers should be idempotent. Metadata updates aren't dispatched, and
+// neither are the deletes of generations replaced by a write.
+type ChangeBridge[T any] struct {
+	cs    *CloudStorage
+	store CRUDStore[T]
+
+	mu       sync.RWMutex
+	handlers []prefixHandler[T]
+}
+
+type prefixHandler[T any] struct {
+	prefix string
+	fn     ChangeHandler[T]
+}
+
+// NewChangeBridge creates a ChangeBridge reading the changed objects from
+// store.
+func NewChangeBridge[T any](cs *CloudStorage, store CRUDStore[T]) *ChangeBridge[T] {
+	return &ChangeBridge[T]{cs: cs, store: store}
+}
+
+// Handle registers fn for the changes of keys starting with prefix. Every
+// matching handler is called, in the order they were registered.
+func (b *ChangeBridge[T]) Handle(prefix string, fn ChangeHandler[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, prefixHandler[T]{prefix, fn})
+}
+
+func (b *ChangeBridge[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	n, err := parseNotification(r)
+	if err != nil {
+		http.Error(w, "invalid notification: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := b.dispatch(r.Context(), n); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *ChangeBridge[T]) dispatch(ctx context.Context, n notification) error {
+	if n.bucket != b.cs.bucketname || n.skip {
+		return nil
+	}
+	key, ok := b.cs.Key(n.name)
+	if !ok {
+		return nil
+	}
+
+	b.mu.RLock()
+	var handlers []ChangeHandler[T]
+	for _, h := range b.handlers {
+		if strings.HasPrefix(key, h.prefix) {
+			handlers = append(handlers, h.fn)
+		}
+	}
+	b.mu.RUnlock()
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	event := ChangeEvent{Type: n.change, Name: n.name, Key: key, Generation: n.generation}
+	var obj *T
+	if event.Type == ObjectDeleted {
+		// without the generation that replaced it, a delete may be part of
+		// a write
+		if attrs, err := b.cs.bucket.Object(n.name).Attrs(ctx); err == nil && attrs.Generation > n.generation {
+			return nil
+		}
+	} else {
+		var err error
+		obj, err = b.read(ctx, key, n.generation)
+		if errors.Is(err, ErrObjectNotFound) {
+			// gone since, its delete or the write replacing it is dispatched
+			// on its own
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for _, fn := range handlers {
+		if err := fn(ctx, event, obj); err != nil {
+			return fmt.Errorf("%s %s: %w", event.Type, key, err)
+		}
+	}
+	return nil
+}
+
+// generationStore is implemented by the stores which can read any generation
+// of an object, see querier.getGeneration.
+type generationStore[T any] interface {
+	getGeneration(ctx context.Context, key string, generation int64) (*T, error)
+}
+
+// read decodes the given generation of the object at key. Stores which can
+// only read the live generation report ErrObjectNotFound if it changed.
+func (b *ChangeBridge[T]) read(ctx context.Context, key string, generation int64) (*T, error) {
+	if generation == 0 {
+		// notifications without a generation are read live
+		return b.store.Get(ctx, key)
+	}
+	if s, ok := b.store.(generationStore[T]); ok {
+		return s.getGeneration(ctx, key, generation)
+	}
+	entry, err := b.store.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	} else if entry.Generation != generation {
+		return nil, &storageError{cause: storage.ErrObjectNotExist, mask: ErrObjectNotFound}
+	}
+	return entry.Value, nil
+}
+
+// notification is an object change notification in either format.
+type notification struct {
+	bucket     string
+	name       string
+	generation int64
+	change     ChangeType
+	// skip is set for notifications which aren't dispatched, metadata
+	// updates and deletes of generations replaced by a write
+	skip bool
+}
+
+// storageObjectData is the payload of both formats, the object resource.
+type storageObjectData struct {
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Generation string `json:"generation"`
+}
+
+// parseNotification parses Eventarc CloudEvents, in binary or structured
+// mode, and Pub/Sub push messages of bucket notifications.
+func parseNotification(r *http.Request) (notification, error) {
+	if ceType := r.Header.Get("Ce-Type"); ceType != "" {
+		var data storageObjectData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return notification{}, err
+		}
+		return cloudEventNotification(ceType, data)
+	}
+
+	var body struct {
+		// structured CloudEvents
+		Type string            `json:"type"`
+		Data storageObjectData `json:"data"`
+		// Pub/Sub push
+		Message *struct {
+			Attributes map[string]string `json:"attributes"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return notification{}, err
+	}
+	if body.Message != nil {
+		return pubsubNotification(body.Message.Attributes)
+	}
+	return cloudEventNotification(body.Type, body.Data)
+}
+
+func cloudEventNotification(ceType string, data storageObjectData) (notification, error) {
+	n := notification{bucket: data.Bucket, name: data.Name}
+	switch ceType {
+	case "google.cloud.storage.object.v1.finalized":
+		n.change = ObjectCreated
+	case "google.cloud.storage.object.v1.deleted", "google.cloud.storage.object.v1.archived":
+		n.change = ObjectDeleted
+	case "google.cloud.storage.object.v1.metadataUpdated":
+		n.skip = true
+	default:
+		return n, fmt.Errorf("unknown event type %q", ceType)
+	}
+	if data.Generation != "" {
+		var err error
+		if n.generation, err = strconv.ParseInt(data.Generation, 10, 64); err != nil {
+			return n, fmt.Errorf("generation: %w", err)
+		}
+	}
+	return n, nil
+}
+
+func pubsubNotification(attrs map[string]string) (notification, error) {
+	n := notification{bucket: attrs["bucketId"], name: attrs["objectId"]}
+	switch attrs["eventType"] {
+	case "OBJECT_FINALIZE":
+		n.change = ObjectCreated
+		if attrs["overwroteGeneration"] != "" {
+			n.change = ObjectUpdated
+		}
+	case "OBJECT_DELETE", "OBJECT_ARCHIVE":
+		n.change = ObjectDeleted
+		n.skip = attrs["overwrittenByGeneration"] != ""
+	case "OBJECT_METADATA_UPDATE":
+		n.skip = true
+	default:
+		return n, fmt.Errorf("unknown event type %q", attrs["eventType"])
+	}
+	if generation := attrs["objectGeneration"]; generation != "" {
+		var err error
+		if n.generation, err = strconv.ParseInt(generation, 10, 64); err != nil {
+			return n, fmt.Errorf("generation: %w", err)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash/crc32"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// ChecksumPolicy selects the checksums computed locally and sent with
+// uploads, letting GCS reject payloads corrupted in transit. GCS computes
+// its own checksums either way.
+type ChecksumPolicy int
+
+const (
+	// ChecksumCRC32C sends the CRC32C of the payload.
+	ChecksumCRC32C ChecksumPolicy = 1 << iota
+	// ChecksumMD5 sends the MD5 hash of the payload.
+	ChecksumMD5
+
+	// ChecksumNone sends no checksums, trading the integrity check for
+	// throughput.
+	ChecksumNone ChecksumPolicy = 0
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// setChecksums computes the checksums of the payload in r required by the
+// checksum policy and sets them on writer. The returned reader yields the
+// whole payload.
+func (cs *CloudStorage) setChecksums(writer *storage.Writer, r io.Reader) (io.Reader, error) {
+	if cs.checksums == ChecksumNone {
+		return r, nil
+	}
+
+	crc := crc32.New(crc32cTable)
+	md := md5.New()
+	hashes := io.MultiWriter(crc, md)
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(hashes, rs); err != nil {
+			return nil, err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, err := io.Copy(io.MultiWriter(hashes, &buf), r); err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(buf.Bytes())
+	}
+
+	if cs.checksums&ChecksumCRC32C != 0 {
+		writer.CRC32C = crc.Sum32()
+		writer.SendCRC32C = true
+	}
+	if cs.checksums&ChecksumMD5 != 0 {
+		writer.MD5 = md.Sum(nil)
+	}
+	return r, nil
+}
@@ -1,70 +1,377 @@
 package objectstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
 )
 
 type CloudStorage struct {
-	client *storage.Client
-	bucket *storage.BucketHandle
+	client     *storage.Client
+	bucket     *storage.BucketHandle
+	bucketname string
+	// readbucket is the bucket on the read endpoint, the bucket without one.
+	readbucket *storage.BucketHandle
 
 	contenttype    string
+	sniff          bool
 	filenameformat string
+	// fileprefix and filesuffix surround the key in the filename format.
+	// Formats with no other verbs are rendered by concatenating them.
+	fileprefix        string
+	filesuffix        string
+	precompiled       bool
+	keyencoding       KeyEncoding
+	normalizekeys     bool
+	jsonencoder       []func(*json.Encoder)
+	publicbaseurl     string
+	userproject       string
+	clientoptions     []option.ClientOption
+	retryoptions      []storage.RetryOption
+	chunksize         int
+	readcompressed    bool
+	checksums         ChecksumPolicy
+	ops               chan struct{}
+	impersonate       string
+	impersonatescopes []string
+	errorobserver     func(op, key string, err error)
+	forbidpublic      bool
+	readendpoint      string
+	latency           func(op string, d time.Duration)
+	listpagesize      int
+	listreadahead     int
+	tagindex          string
 }
 
 // WithFilenameFormat defines the filename format string with its only parameter being the object key.
 // Defaults to `%s.json`
 type WithFilenameFormat string
 
+// WithKeyEncoding encodes keys into object names, e.g. for keys that are
+// emails or free text. Listings decode the names back into keys and take key
+// prefixes and ranges instead of object names. Both encodings preserve the
+// order of keys, so they list exactly the keys asked for; delimiters and
+// globs still match the encoded names though.
+// Defaults to KeyEncodingNone
+type WithKeyEncoding KeyEncoding
+
+// WithKeyNormalization normalizes keys to Unicode NFC before they are turned
+// into object names, so keys with accented characters arriving in NFC and
+// NFD form from different clients address the same object.
+// Defaults to `false`
+type WithKeyNormalization bool
+
 // WithContentType defines the MIME type of the file content.
 // Defaults to `application/json`
 type WithContentType string
 
+// WithContentTypeSniffing makes WriteFile detect the MIME type of each file
+// from its first 512 bytes instead of using the configured content type,
+// e.g. for buckets of user uploaded images and CSVs.
+// Defaults to `false`
+type WithContentTypeSniffing bool
+
+// WithJSONEncoder configures the json.Encoder used when writing objects.
+// Defaults to the behavior of `json.Marshal`
+type WithJSONEncoder func(*json.Encoder)
+
+// WithJSONIndent indents written objects, e.g. for human-browsable buckets.
+// Defaults to no indentation
+type WithJSONIndent string
+
+// WithEscapeHTML controls whether <, > and & are escaped in written JSON strings.
+// Defaults to `true`
+type WithEscapeHTML bool
+
+// WithPublicBaseURL defines the base URL PublicURL renders object URLs from,
+// e.g. a CDN domain fronting the bucket.
+// Defaults to `https://storage.googleapis.com/<bucket>`
+type WithPublicBaseURL string
+
+// WithUserProject defines the project billed for requests, which is required
+// for requester-pays buckets owned by other projects.
+// Defaults to the bucket's own project
+type WithUserProject string
+
+// WithClientOptions are passed to the underlying storage client, e.g. to use a
+// JSON key file, custom token source or HTTP client.
+// Defaults to application default credentials
+type WithClientOptions []option.ClientOption
+
+// WithRetryPolicy configures how the storage client retries failed requests,
+// e.g. storage.WithBackoff, storage.WithPolicy(storage.RetryAlways) to also
+// retry operations that aren't idempotent, or storage.WithErrorFunc.
+// Defaults to the storage client's defaults
+type WithRetryPolicy []storage.RetryOption
+
+// WithWriterChunkSize sets the buffer size of every writer, which is also the
+// size of the chunks uploads are split into. 0 uploads in a single request
+// without buffering, which also disables retries of failed uploads.
+// Defaults to 16 MiB, or the object size for small payloads of known size
+type WithWriterChunkSize int
+
+// WithReadCompressed makes reads of objects stored with gzip content encoding
+// return the compressed bytes instead of having them decompressed by GCS.
+// Defaults to `false`, reading decompressed payloads
+type WithReadCompressed bool
+
+// WithChecksumPolicy sets the checksums sent with every upload, e.g.
+// ChecksumCRC32C|ChecksumMD5. Checksums must be known before the upload
+// starts, so payloads from readers that can't seek are buffered in memory.
+// Defaults to ChecksumNone
+type WithChecksumPolicy ChecksumPolicy
+
+// WithMaxConcurrentOps caps the number of reads, writes and deletes running
+// at the same time on the CloudStorage. Further operations wait for a slot,
+// so a runaway fan-out can't open thousands of streams at once.
+// Defaults to `0`, unlimited
+type WithMaxConcurrentOps int
+
+// WithImpersonation makes the client act as the service account email
+// targetSA, using the base credentials to mint short-lived tokens for scopes.
+// The base credentials need the Service Account Token Creator role on it.
+// Scopes default to storage.ScopeFullControl if none are given.
+// Defaults to no impersonation
+func WithImpersonation(targetSA string, scopes ...string) Option {
+	return withImpersonation{targetSA, scopes}
+}
+
+type withImpersonation struct {
+	targetSA string
+	scopes   []string
+}
+
+// WithErrorObserver is called with every failed read, write and delete, and
+// the failed runs of Snapshotter.Run, e.g. to report storage failures to
+// alerting. Missing objects aren't
+// reported since they are usually expected.
+// Defaults to no observer
+type WithErrorObserver func(op, key string, err error)
+
+// WithForbidPublicAccess makes MakePublic, and GrantACL to public entities,
+// fail with ErrPublicAccessForbidden, a safety rail for buckets whose objects
+// must never be world-readable.
+// Defaults to `false`
+type WithForbidPublicAccess bool
+
+// WithReadEndpoint routes downloads through the given endpoint, e.g. the
+// regional endpoint closest to the service for dual-region buckets. Writes,
+// deletes and bucket operations keep using the default endpoint.
+// Defaults to the default endpoint
+type WithReadEndpoint string
+
+// WithLatencyObserver is called with the duration of every read, write and
+// delete, e.g. to verify that WithReadEndpoint actually lowers read latency.
+// Reads opening a stream are measured until the first byte.
+// Defaults to no observer
+type WithLatencyObserver func(op string, d time.Duration)
+
+// WithListPageSize sets the number of objects fetched per listing request.
+// Larger pages need fewer round trips for large listings, smaller ones less
+// memory per page.
+// Defaults to the SDK default, currently 1000
+type WithListPageSize int
+
+// WithListReadAhead buffers up to the given number of listed objects ahead of
+// bulk operations such as ForEach and GetAll, so the next page is fetched
+// while the current one is processed.
+// Defaults to `0`, no read-ahead
+type WithListReadAhead int
+
+// WithTagIndex writes an empty index object under the given prefix for every
+// tag added with Tag, so ListByTag lists the index instead of scanning the
+// metadata of the whole bucket. The prefix must not overlap the prefixes of
+// stores, e.g. `_tags/`.
+// Defaults to no index
+type WithTagIndex string
+
 // NewCloudStorage
 func NewCloudStorage(bucket string, opts ...Option) (*CloudStorage, error) {
-	client, err := storage.NewClient(context.TODO())
+	cs := &CloudStorage{
+		bucketname:     bucket,
+		contenttype:    "application/json",
+		filenameformat: "%s.json",
+		publicbaseurl:  "https://storage.googleapis.com/" + bucket,
+		chunksize:      -1,
+	}
+	for _, opt := range opts {
+		opt.apply(cs)
+	}
+	cs.compileFilenameFormat()
+
+	clientoptions := cs.clientoptions
+	if cs.impersonate != "" {
+		ts, err := impersonate.CredentialsTokenSource(context.TODO(), impersonate.CredentialsConfig{
+			TargetPrincipal: cs.impersonate,
+			Scopes:          cs.impersonatescopes,
+		}, cs.clientoptions...)
+		if err != nil {
+			return nil, fmt.Errorf("cloud_storage impersonate: %w", err)
+		}
+		clientoptions = append(clientoptions, option.WithTokenSource(ts))
+	}
+
+	client, err := storage.NewClient(context.TODO(), clientoptions...)
 	if err != nil {
 		return nil, fmt.Errorf("cloud_storage client: %w", err)
 	}
+	cs.client = client
+	cs.bucket = cs.bucketHandle(client)
+	cs.readbucket = cs.bucket
+	if cs.readendpoint != "" {
+		readclient, err := storage.NewClient(context.TODO(), append(clientoptions, option.WithEndpoint(cs.readendpoint))...)
+		if err != nil {
+			return nil, fmt.Errorf("cloud_storage read client: %w", err)
+		}
+		cs.readbucket = cs.bucketHandle(readclient)
+	}
 
 	// safety check that bucket exists and we're allowed to do a basic op on it
-	_, err = client.Bucket(bucket).Object("nonexistant123").Attrs(context.TODO())
+	_, err = cs.bucket.Object("nonexistant123").Attrs(context.TODO())
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
 		return nil, fmt.Errorf("init check: %w", err)
 	}
 
-	cs := &CloudStorage{
-		client:         client,
-		bucket:         client.Bucket(bucket),
-		contenttype:    "application/json",
-		filenameformat: "%s.json",
+	return cs, nil
+}
+
+// bucketHandle returns the handle of the bucket on client configured with the
+// bucket options.
+func (cs *CloudStorage) bucketHandle(client *storage.Client) *storage.BucketHandle {
+	bucket := client.Bucket(cs.bucketname)
+	if cs.userproject != "" {
+		bucket = bucket.UserProject(cs.userproject)
 	}
-	for _, opt := range opts {
-		opt.apply(cs)
+	if len(cs.retryoptions) > 0 {
+		bucket = bucket.Retryer(cs.retryoptions...)
 	}
-	return cs, nil
+	return bucket
+}
+
+// compileFilenameFormat splits the filename format around its key verb.
+func (cs *CloudStorage) compileFilenameFormat() {
+	i := strings.Index(cs.filenameformat, "%s")
+	if i < 0 {
+		return
+	}
+	cs.fileprefix, cs.filesuffix = cs.filenameformat[:i], cs.filenameformat[i+2:]
+	cs.precompiled = strings.Count(cs.filenameformat, "%") == 1
 }
 
 func (cs *CloudStorage) Filename(key string) string {
+	key = cs.keyencoding.encode(cs.normalize(key))
+	if cs.precompiled {
+		return cs.fileprefix + key + cs.filesuffix
+	}
 	return fmt.Sprintf(cs.filenameformat, key)
 }
 
+// Key is the inverse of Filename. It reports false if name does not match the
+// filename format.
+func (cs *CloudStorage) Key(name string) (string, bool) {
+	if !strings.Contains(cs.filenameformat, "%s") {
+		return "", false
+	}
+	prefix, suffix := cs.fileprefix, cs.filesuffix
+	if len(name) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return cs.keyencoding.decode(name[len(prefix) : len(name)-len(suffix)])
+}
+
+// Marshal encodes v the same way the stores do when writing objects.
+func (cs *CloudStorage) Marshal(v any) ([]byte, error) {
+	if len(cs.jsonencoder) == 0 {
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, configure := range cs.jsonencoder {
+		configure(enc)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PublicURL renders the public URL of the object at key. The object is only
+// reachable through it if the object or bucket is publicly readable.
+func (cs *CloudStorage) PublicURL(key string) string {
+	segments := strings.Split(cs.Filename(key), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return cs.publicbaseurl + "/" + strings.Join(segments, "/")
+}
+
+// FilenamePrefix returns the object name prefix shared by all keys starting
+// with keyPrefix, e.g. for listing keys by prefix when the filename format
+// contains directories. With KeyEncodingOrderedBase64 the prefix may also match
+// some other keys, which need to be filtered after decoding them.
+func (cs *CloudStorage) FilenamePrefix(keyPrefix string) string {
+	return cs.fileprefix + cs.keyencoding.encodePrefix(cs.normalize(keyPrefix))
+}
+
+// normalize applies WithKeyNormalization to key.
+func (cs *CloudStorage) normalize(key string) string {
+	if cs.normalizekeys {
+		return norm.NFC.String(key)
+	}
+	return key
+}
+
 func (cs *CloudStorage) WriteFile(ctx context.Context, key string, reader io.Reader) error {
-	o := cs.bucket.Object(cs.Filename(key)).
-		If(storage.Conditions{DoesNotExist: true})
+	if cs.sniff {
+		return cs.WriteFileAs(ctx, key, reader, "")
+	}
+	return cs.writeFile(ctx, key, reader, cs.contenttype)
+}
+
+// writeFile creates the object at key, failing if it already exists.
+func (cs *CloudStorage) writeFile(ctx context.Context, key string, reader io.Reader, contentType string) error {
+	return cs.writeFileIf(ctx, key, reader, contentType, storage.Conditions{DoesNotExist: true})
+}
+
+// writeFileIf writes the object at key if conds hold. configure can set
+// further attributes of the object on the writer.
+func (cs *CloudStorage) writeFileIf(ctx context.Context, key string, reader io.Reader, contentType string, conds storage.Conditions, configure ...func(*storage.Writer)) (err error) {
+	defer cs.finish("Write", key, &err)
+	defer cs.measure("Write", time.Now())
+
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	o := cs.bucket.Object(cs.Filename(key))
+	if conds != (storage.Conditions{}) {
+		o = o.If(conds)
+	}
 
 	cctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	writer := o.NewWriter(cctx)
-	writer.ContentType = cs.contenttype
-	if s, ok := reader.(interface{ Size() int64 }); ok {
+	writer := cs.newWriter(cctx, o)
+	writer.ContentType = contentType
+	for _, fn := range configure {
+		fn(writer)
+	}
+	if s, ok := reader.(interface{ Size() int64 }); ok && cs.chunksize < 0 {
 		size := s.Size()
 		// try to upload small files directly we could omit chunking
 		// altogether but that automatically disables the built-in re-try behavior
@@ -72,25 +379,99 @@ func (cs *CloudStorage) WriteFile(ctx context.Context, key string, reader io.Rea
 			writer.ChunkSize = int(size) + 100
 		}
 	}
+	if reader, err = cs.setChecksums(writer, reader); err != nil {
+		return err
+	}
 
 	if _, err := io.Copy(writer, reader); err != nil {
-		return err
+		return wrapWriteError(withDetails("Write", key, err), conds)
 	}
 	if err := writer.Close(); err != nil {
 		// NOTE (Axel): Close()ing will commit any data written, so only do it in the happy path
-		return err
+		return wrapWriteError(withDetails("Write", key, err), conds)
 	}
 	return nil
 }
 
-func (cs *CloudStorage) GetFile(ctx context.Context, key string) ([]byte, error) {
-	reader, err := cs.bucket.Object(cs.Filename(key)).NewReader(ctx)
-	if err2 := wrapStorageError(err); err2 != nil {
+// newWriter creates a writer for o configured with the writer options.
+func (cs *CloudStorage) newWriter(ctx context.Context, o *storage.ObjectHandle) *storage.Writer {
+	writer := o.NewWriter(ctx)
+	if cs.chunksize >= 0 {
+		writer.ChunkSize = cs.chunksize
+	}
+	return writer
+}
+
+// newReader opens a reader on o configured with the reader options.
+func (cs *CloudStorage) newReader(ctx context.Context, o *storage.ObjectHandle) (*storage.Reader, error) {
+	return o.ReadCompressed(cs.readcompressed).NewReader(ctx)
+}
+
+// putFile creates or replaces the object at key. An existing object is only
+// replaced if it hasn't changed since its attributes were read, or since it
+// was at generation if that is known. configure can set further attributes of
+// the object on the writer.
+func (cs *CloudStorage) putFile(ctx context.Context, key string, reader io.Reader, contentType string, generation int64, configure ...func(*storage.Writer)) (err error) {
+	defer cs.finish("Put", key, &err)
+	defer cs.measure("Put", time.Now())
+
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	defer release()
+
+	o := cs.bucket.Object(cs.Filename(key))
+
+	// add compare-and-swap style updating so we don't overwrite with stale read
+	var conds storage.Conditions
+	if generation != 0 {
+		conds = storage.Conditions{GenerationMatch: generation}
+		o = o.If(conds)
+	} else if attrs, aerr := o.Attrs(ctx); aerr == nil {
+		conds = storage.Conditions{GenerationMatch: attrs.Generation}
+		o = o.If(conds)
+	} else if !errors.Is(aerr, storage.ErrObjectNotExist) {
+		return fmt.Errorf("Put %s: Attrs: %w", key, withDetails("Put", key, aerr))
+	}
+
+	writer := cs.newWriter(ctx, o)
+	writer.ContentType = contentType
+	for _, fn := range configure {
+		fn(writer)
+	}
+	if reader, err = cs.setChecksums(writer, reader); err != nil {
+		return fmt.Errorf("Put %s: checksum: %w", key, err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("Put %s: copy: %w", key, wrapWriteError(withDetails("Put", key, err), conds))
+	}
+	if err := writer.Close(); err != nil {
+		// NOTE (Axel): Close()ing will commit any data written, so only do it in the happy path
+		return fmt.Errorf("Put %s: Close: %w", key, wrapWriteError(withDetails("Put", key, err), conds))
+	}
+
+	return nil
+}
+
+func (cs *CloudStorage) GetFile(ctx context.Context, key string) (_ []byte, err error) {
+	defer cs.finish("Get", key, &err)
+	defer cs.measure("Get", time.Now())
+
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get %s: %w", key, err)
+	}
+	defer release()
+
+	reader, err := cs.newReader(ctx, cs.readObject(cs.Filename(key)))
+	if err2 := wrapStorageError(withDetails("Get", key, err)); err2 != nil {
 		return nil, fmt.Errorf("Get %s: %w", key, err2)
 	}
 	defer reader.Close()
 
-	data, err := ioutil.ReadAll(reader)
+	data, err := readAll(ctx, reader, reader.Attrs.Size)
 	if err != nil {
 		return nil, fmt.Errorf("Get %s: readall: %w", key, err)
 	}
@@ -98,6 +479,134 @@ func (cs *CloudStorage) GetFile(ctx context.Context, key string) ([]byte, error)
 	return data, nil
 }
 
+// openIfChanged opens a reader on the object at key unless its generation
+// equals generation, in which case ErrNotModified is returned. Reads ignore
+// generation-not-match conditions, so the generation is compared using the
+// object metadata before downloading.
+func (cs *CloudStorage) openIfChanged(ctx context.Context, key string, generation int64) (_ *storage.Reader, err error) {
+	defer cs.finish("Get", key, &err)
+	defer cs.measure("Get", time.Now())
+
+	o := cs.readObject(cs.Filename(key))
+	if generation != 0 {
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			return nil, wrapStorageError(withDetails("Get", key, err))
+		}
+		if attrs.Generation == generation {
+			return nil, ErrNotModified
+		}
+		o = o.Generation(attrs.Generation)
+	}
+
+	reader, err := cs.newReader(ctx, o)
+	if err != nil {
+		return nil, wrapStorageError(withDetails("Get", key, err))
+	}
+	return reader, nil
+}
+
+// deleteFile deletes the object at key.
+func (cs *CloudStorage) deleteFile(ctx context.Context, key string) error {
+	return cs.deleteFileIf(ctx, key, storage.Conditions{})
+}
+
+// deleteFileIf deletes the object at key if it matches conds.
+func (cs *CloudStorage) deleteFileIf(ctx context.Context, key string, conds storage.Conditions) (err error) {
+	defer cs.finish("Delete", key, &err)
+	defer cs.measure("Delete", time.Now())
+
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Delete %s: %w", key, err)
+	}
+	defer release()
+
+	o := cs.bucket.Object(cs.Filename(key))
+	if conds != (storage.Conditions{}) {
+		o = o.If(conds)
+	}
+	err = cs.wrapHeldError(ctx, o, withDetails("Delete", key, o.Delete(ctx)))
+	if err2 := wrapStorageError(err); err2 != nil {
+		return fmt.Errorf("Delete %s: %w", key, err2)
+	} else if err != nil {
+		return fmt.Errorf("Delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// list iterates over all objects under prefix.
+func (cs *CloudStorage) list(ctx context.Context, prefix string, attrs ...string) *storage.ObjectIterator {
+	return cs.listDelimited(ctx, prefix, "", attrs...)
+}
+
+// listDelimited iterates over the objects under prefix whose names don't
+// contain delimiter after the prefix. Names which do are rolled up into
+// synthetic directories, returned as attributes with only Prefix set.
+func (cs *CloudStorage) listDelimited(ctx context.Context, prefix, delimiter string, attrs ...string) *storage.ObjectIterator {
+	query := cs.keyQuery(prefix)
+	query.Delimiter = delimiter
+	return cs.objects(ctx, query, attrs)
+}
+
+// listNames iterates over the objects whose names start with prefix,
+// regardless of the key encoding, e.g. for objects not named by Filename.
+func (cs *CloudStorage) listNames(ctx context.Context, prefix string, attrs ...string) *storage.ObjectIterator {
+	return cs.objects(ctx, &storage.Query{Prefix: prefix}, attrs)
+}
+
+// keyQuery returns the query listing the keys starting with prefix. The
+// base64 encodings of keys with a prefix don't share a name prefix, so
+// encoded keys are listed as the range of names from the prefix to its
+// successor, which holds exactly those keys as the encodings keep their order.
+func (cs *CloudStorage) keyQuery(prefix string) *storage.Query {
+	if cs.keyencoding == KeyEncodingNone {
+		return &storage.Query{Prefix: prefix}
+	}
+	query := &storage.Query{Prefix: cs.FilenamePrefix(prefix)}
+	if prefix = cs.normalize(prefix); prefix != "" {
+		query.StartOffset = cs.fileprefix + cs.keyencoding.encode(prefix)
+		if end, ok := successor(prefix); ok {
+			query.EndOffset = cs.fileprefix + cs.keyencoding.encode(end)
+		}
+	}
+	return query
+}
+
+// listGlob iterates over the objects whose names match glob.
+func (cs *CloudStorage) listGlob(ctx context.Context, glob string, attrs ...string) *storage.ObjectIterator {
+	return cs.objects(ctx, &storage.Query{MatchGlob: glob}, attrs)
+}
+
+// listRange iterates over the objects with names in [start, end). An empty end
+// lists to the end of the bucket. With a key encoding, start and end are keys.
+func (cs *CloudStorage) listRange(ctx context.Context, start, end string, attrs ...string) *storage.ObjectIterator {
+	if cs.keyencoding != KeyEncodingNone {
+		query := &storage.Query{Prefix: cs.fileprefix, StartOffset: cs.fileprefix + cs.keyencoding.encode(cs.normalize(start))}
+		if end != "" {
+			query.EndOffset = cs.fileprefix + cs.keyencoding.encode(cs.normalize(end))
+		}
+		return cs.objects(ctx, query, attrs)
+	}
+	return cs.objects(ctx, &storage.Query{
+		StartOffset: start,
+		EndOffset:   end,
+	}, attrs)
+}
+
+// objects runs query, only fetching the given attributes if any. An invalid
+// attribute selection falls back to fetching all attributes.
+func (cs *CloudStorage) objects(ctx context.Context, query *storage.Query, attrs []string) *storage.ObjectIterator {
+	query.Projection = storage.ProjectionNoACL // skip some metadata to speed up
+	// an invalid selection leaves the query unchanged
+	_ = query.SetAttrSelection(attrs)
+	it := cs.bucket.Objects(ctx, query)
+	if cs.listpagesize > 0 {
+		it.PageInfo().MaxSize = cs.listpagesize
+	}
+	return it
+}
+
 func (cs *CloudStorage) Object(ctx context.Context, key string) *storage.ObjectHandle {
 	return cs.bucket.Object(cs.Filename(key))
 }
@@ -105,9 +614,70 @@ func (cs *CloudStorage) Object(ctx context.Context, key string) *storage.ObjectH
 // Options configures the CloudStorage.
 //
 //	WithFilenameFormat
+//	WithKeyEncoding
+//	WithKeyNormalization
+//	WithContentType
+//	WithContentTypeSniffing
+//	WithJSONEncoder
+//	WithJSONIndent
+//	WithEscapeHTML
+//	WithPublicBaseURL
+//	WithUserProject
+//	WithClientOptions
+//	WithRetryPolicy
+//	WithWriterChunkSize
+//	WithReadCompressed
+//	WithChecksumPolicy
+//	WithMaxConcurrentOps
+//	WithImpersonation
+//	WithErrorObserver
+//	WithForbidPublicAccess
+//	WithReadEndpoint
+//	WithLatencyObserver
+//	WithListPageSize
+//	WithListReadAhead
+//	WithTagIndex
 type Option interface {
 	apply(*CloudStorage)
 }
 
-func (o WithFilenameFormat) apply(cs *CloudStorage) { cs.filenameformat = string(o) }
-func (o WithContentType) apply(cs *CloudStorage)    { cs.contenttype = string(o) }
+func (o WithFilenameFormat) apply(cs *CloudStorage)      { cs.filenameformat = string(o) }
+func (o WithKeyEncoding) apply(cs *CloudStorage)         { cs.keyencoding = KeyEncoding(o) }
+func (o WithKeyNormalization) apply(cs *CloudStorage)    { cs.normalizekeys = bool(o) }
+func (o WithContentType) apply(cs *CloudStorage)         { cs.contenttype = string(o) }
+func (o WithContentTypeSniffing) apply(cs *CloudStorage) { cs.sniff = bool(o) }
+func (o WithJSONEncoder) apply(cs *CloudStorage)         { cs.jsonencoder = append(cs.jsonencoder, o) }
+func (o WithUserProject) apply(cs *CloudStorage)         { cs.userproject = string(o) }
+func (o WithClientOptions) apply(cs *CloudStorage)       { cs.clientoptions = append(cs.clientoptions, o...) }
+func (o WithRetryPolicy) apply(cs *CloudStorage)         { cs.retryoptions = append(cs.retryoptions, o...) }
+func (o WithWriterChunkSize) apply(cs *CloudStorage)     { cs.chunksize = int(o) }
+func (o WithReadCompressed) apply(cs *CloudStorage)      { cs.readcompressed = bool(o) }
+func (o WithChecksumPolicy) apply(cs *CloudStorage)      { cs.checksums = ChecksumPolicy(o) }
+func (o WithErrorObserver) apply(cs *CloudStorage)       { cs.errorobserver = o }
+func (o WithForbidPublicAccess) apply(cs *CloudStorage)  { cs.forbidpublic = bool(o) }
+func (o WithReadEndpoint) apply(cs *CloudStorage)        { cs.readendpoint = string(o) }
+func (o WithLatencyObserver) apply(cs *CloudStorage)     { cs.latency = o }
+func (o WithListPageSize) apply(cs *CloudStorage)        { cs.listpagesize = int(o) }
+func (o WithListReadAhead) apply(cs *CloudStorage)       { cs.listreadahead = int(o) }
+func (o WithTagIndex) apply(cs *CloudStorage)            { cs.tagindex = string(o) }
+func (o withImpersonation) apply(cs *CloudStorage) {
+	cs.impersonate, cs.impersonatescopes = o.targetSA, o.scopes
+	if len(cs.impersonatescopes) == 0 {
+		cs.impersonatescopes = []string{storage.ScopeFullControl}
+	}
+}
+func (o WithPublicBaseURL) apply(cs *CloudStorage) {
+	cs.publicbaseurl = strings.TrimSuffix(string(o), "/")
+}
+func (o WithJSONIndent) apply(cs *CloudStorage) {
+	cs.jsonencoder = append(cs.jsonencoder, func(enc *json.Encoder) { enc.SetIndent("", string(o)) })
+}
+func (o WithEscapeHTML) apply(cs *CloudStorage) {
+	cs.jsonencoder = append(cs.jsonencoder, func(enc *json.Encoder) { enc.SetEscapeHTML(bool(o)) })
+}
+func (o WithMaxConcurrentOps) apply(cs *CloudStorage) {
+	cs.ops = nil
+	if o > 0 {
+		cs.ops = make(chan struct{}, int(o))
+	}
+}
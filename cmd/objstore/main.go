@@ -0,0 +1,267 @@
+// Command objstore reads and writes the objects of a bucket the way the
+// objectstore package does, deriving object names from keys with the same
+// filename format.
+//
+// Usage:
+//
+//	objstore [flags] get <key>
+//	objstore [flags] put <key> < value.json
+//	objstore [flags] delete <key>
+//	objstore [flags] list [prefix]
+//	objstore [flags] export [prefix] > objects.jsonl
+//	objstore [flags] import < objects.jsonl
+//	objstore [flags] stats [prefix]
+//
+// Exports are JSON lines of {"key": ..., "value": ...}, which import reads back.
+// With -progress, export and import report the processed objects on stderr.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	bucket      = flag.String("bucket", "", "name of the bucket")
+	format      = flag.String("format", "%s.json", "filename format of the objects")
+	contentType = flag.String("content-type", "application/json", "content type of written objects")
+	userProject = flag.String("user-project", "", "project billed for requests")
+	concurrency = flag.Int("concurrency", 8, "number of concurrent downloads in export")
+	progress    = flag.Bool("progress", false, "report the progress of export and import on stderr")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: objstore [flags] get|put|delete|list|export|import|stats [args]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *bucket == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "objstore:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cmd string, args []string) error {
+	opts := []objectstore.Option{
+		objectstore.WithFilenameFormat(*format),
+		objectstore.WithContentType(*contentType),
+	}
+	if *userProject != "" {
+		opts = append(opts, objectstore.WithUserProject(*userProject))
+	}
+	cs, err := objectstore.NewCloudStorage(*bucket, opts...)
+	if err != nil {
+		return err
+	}
+	store := objectstore.NewCRUDStore[json.RawMessage](cs, objectstore.WithConcurrency(*concurrency))
+
+	switch cmd {
+	case "get":
+		if len(args) != 1 {
+			return errors.New("get: expected a key")
+		}
+		data, err := cs.GetFile(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+
+	case "put":
+		if len(args) != 1 {
+			return errors.New("put: expected a key")
+		}
+		value, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(value) {
+			return errors.New("put: stdin is not valid JSON")
+		}
+		return store.Put(ctx, args[0], json.RawMessage(value))
+
+	case "delete":
+		if len(args) != 1 {
+			return errors.New("delete: expected a key")
+		}
+		return store.Delete(ctx, args[0])
+
+	case "list":
+		it := store.List(ctx, prefixArg(cs, args))
+		for {
+			attrs, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if key, ok := cs.Key(attrs.Name); ok {
+				fmt.Println(key)
+			}
+		}
+
+	case "export":
+		return export(ctx, cs, store, prefixArg(cs, args), progressFunc())
+
+	case "import":
+		dec := json.NewDecoder(os.Stdin)
+		for n := 1; ; n++ {
+			var obj exported
+			if err := dec.Decode(&obj); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("import: object %d: %w", n, err)
+			}
+			if err := store.Put(ctx, obj.Key, obj.Value); err != nil {
+				return err
+			}
+			if report := progressFunc(); report != nil {
+				// the total of a stream isn't known up front
+				report(n, n, obj.Key)
+			}
+		}
+
+	case "stats":
+		stats, err := cs.Stats(ctx, prefixArg(cs, args))
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// exported is a line of an export.
+type exported struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// export writes the objects under prefix to stdout as they are downloaded,
+// so exports of large prefixes aren't held in memory. The keys are listed up
+// front to know the total for progress, which may be nil.
+func export(ctx context.Context, cs *objectstore.CloudStorage, store objectstore.CRUDStore[json.RawMessage], prefix string, progress objectstore.ProgressFunc) error {
+	var keys []string
+	it := store.List(ctx, prefix)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if key, ok := cs.Key(attrs.Name); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		done     int
+		firstErr error
+	)
+	w := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(w)
+	write := func(key string, value *json.RawMessage, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil && value != nil {
+			err = enc.Encode(exported{Key: key, Value: *value})
+		}
+		if errors.Is(err, objectstore.ErrObjectNotFound) {
+			err = nil // deleted since it was listed
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			return
+		}
+		done++
+		if progress != nil {
+			progress(done, len(keys), key)
+		}
+	}
+
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				value, err := store.Get(ctx, key)
+				write(key, value, err)
+			}
+		}()
+	}
+feed:
+	for _, key := range keys {
+		select {
+		case queue <- key:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// progressFunc returns the ProgressFunc reporting on stderr with -progress,
+// nil without.
+func progressFunc() objectstore.ProgressFunc {
+	if !*progress {
+		return nil
+	}
+	return func(done, total int, lastKey string) {
+		fmt.Fprintf(os.Stderr, "%d/%d %s\n", done, total, lastKey)
+	}
+}
+
+// prefixArg returns the object name prefix of the optional key prefix
+// argument, so `list users/` lists the keys starting with `users/` whatever
+// the filename format.
+func prefixArg(cs *objectstore.CloudStorage, args []string) string {
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	return cs.FilenamePrefix(prefix)
+}
@@ -0,0 +1,182 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+)
+
+// Codec encodes the objects of a CRUDStore into the bytes stored in the bucket
+// and back. ContentType is set on the objects it writes.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	ContentType() string
+}
+
+// jsonCodec is the default Codec, encoding with the JSON options of the
+// CloudStorage.
+type jsonCodec struct {
+	cs *CloudStorage
+}
+
+func (c jsonCodec) Marshal(v any) ([]byte, error)      { return c.cs.Marshal(v) }
+func (c jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (c jsonCodec) ContentType() string                { return c.cs.contenttype }
+
+// JSONCodec returns the default Codec of stores, encoding with the JSON
+// options of the CloudStorage, e.g. to wrap it in a SigningCodec.
+func (cs *CloudStorage) JSONCodec() Codec { return jsonCodec{cs} }
+
+// MigrationCodec moves a store from a Legacy codec to a Current one. Objects
+// are always written with Current, and read with Legacy when Current fails to
+// decode them. With Rewrite, objects decoded with Legacy are written back
+// encoded with Current, unless they changed since they were read. Migrate
+// rewrites all objects of a prefix at once.
+type MigrationCodec struct {
+	Current Codec
+	Legacy  Codec
+	Rewrite bool
+}
+
+func (c *MigrationCodec) Marshal(v any) ([]byte, error) { return c.Current.Marshal(v) }
+func (c *MigrationCodec) ContentType() string           { return c.Current.ContentType() }
+
+func (c *MigrationCodec) Unmarshal(data []byte, v any) error {
+	_, err := c.unmarshal(data, v)
+	return err
+}
+
+// unmarshal reports whether data had to be decoded with the legacy codec.
+func (c *MigrationCodec) unmarshal(data []byte, v any) (bool, error) {
+	err := c.Current.Unmarshal(data, v)
+	if err == nil {
+		return false, nil
+	}
+	if c.Legacy.Unmarshal(data, v) != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// decode decodes the object read from the given generation of key, migrating
+// it to the current codec if it is stored in a legacy format.
+func (q *querier[T]) decode(ctx context.Context, key string, data []byte, generation int64) (*T, error) {
+	var obj T
+	migration, ok := q.codec.(*MigrationCodec)
+	if !ok {
+		if err := q.codec.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		return &obj, nil
+	}
+
+	legacy, err := migration.unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+	if legacy && migration.Rewrite && generation != 0 {
+		// best effort, the object stays readable in the legacy format
+		if encoded, err := q.codec.Marshal(&obj); err == nil {
+			conds := storage.Conditions{GenerationMatch: generation}
+			_ = q.cs.writeFileIf(ctx, key, bytes.NewReader(encoded), q.codec.ContentType(), conds, q.writeAttrs(encoded)...)
+		}
+	}
+	return &obj, nil
+}
+
+// Migrate rewrites every object under prefix still stored in the legacy
+// format of the MigrationCodec of store, which must be created by
+// NewCRUDStore WithCodec, encoding it with the current codec. Objects changed
+// since they were read are left to their writer. progress may be nil.
+func Migrate[T any](ctx context.Context, store CRUDStore[T], prefix string, progress ProgressFunc) (err error) {
+	q, ok := store.(*querier[T])
+	if !ok {
+		return fmt.Errorf("Migrate %s: store isn't created by NewCRUDStore", prefix)
+	}
+	defer q.cs.finish("Migrate", prefix, &err)
+	migration, ok := q.codec.(*MigrationCodec)
+	if !ok {
+		return fmt.Errorf("Migrate %s: store has no MigrationCodec", prefix)
+	}
+
+	keys, err := q.cs.listKeys(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("Migrate %s: %w", prefix, err)
+	}
+	for i, key := range keys {
+		if err := q.migrate(ctx, key, migration); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return fmt.Errorf("Migrate %s: %s: %w", prefix, key, err)
+		}
+		if progress != nil {
+			progress(i+1, len(keys), key)
+		}
+	}
+	return nil
+}
+
+// migrate rewrites the object at key with the current codec of migration if
+// it is stored in the legacy format.
+func (q *querier[T]) migrate(ctx context.Context, key string, migration *MigrationCodec) error {
+	reader, err := q.open(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	var obj T
+	legacy, err := migration.unmarshal(data, &obj)
+	if err != nil || !legacy {
+		return err
+	}
+	encoded, err := q.codec.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	conds := storage.Conditions{GenerationMatch: reader.Attrs.Generation}
+	err = q.cs.writeFileIf(ctx, key, bytes.NewReader(encoded), q.codec.ContentType(), conds, q.writeAttrs(encoded)...)
+	if isPreconditionFailed(err) {
+		return nil
+	}
+	q.cache.evict(key)
+	return err
+}
+
+// document converts the stored data to JSON for operations working on the
+// JSON representation, such as Patch and GetField.
+func (q *querier[T]) document(data []byte) ([]byte, error) {
+	if _, ok := q.codec.(jsonCodec); ok {
+		return data, nil
+	}
+	var obj T
+	if err := q.codec.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&obj)
+}
+
+// writeAttrs returns the writer options setting the metadata of objects
+// written with data, i.e. the codec pipeline and the integrity hash.
+func (q *querier[T]) writeAttrs(data []byte) []func(*storage.Writer) {
+	configure := q.integrity(data)
+	if chain, ok := q.codec.(*chainCodec); ok {
+		configure = append(configure, func(w *storage.Writer) { setMetadata(w, metaCodec, chain.pipeline()) })
+	}
+	return configure
+}
+
+func setMetadata(w *storage.Writer, key, value string) {
+	if w.Metadata == nil {
+		w.Metadata = make(map[string]string)
+	}
+	w.Metadata[key] = value
+}
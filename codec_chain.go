@@ -0,0 +1,160 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// metaCodec records the pipeline of a ChainCodec on the objects it writes.
+const metaCodec = "objectstore-codec"
+
+// Transformer transforms encoded payloads, e.g. compressing or encrypting
+// them. Decode reverses Encode. Name identifies the transformation in the
+// object metadata.
+type Transformer interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+	Name() string
+}
+
+// ChainCodec encodes objects with codec and passes the payload through
+// transformers in order, e.g.
+//
+//	ChainCodec(cs.JSONCodec(), Gzip(gzip.BestSpeed), encryption)
+//
+// encodes, then compresses, then encrypts. Decoding runs the transformers in
+// reverse order before decoding with codec. Objects keep the content type of
+// codec, describing the logical format, and their `objectstore-codec`
+// metadata records the pipeline, e.g. `json+gzip+aes-gcm`, for operators.
+func ChainCodec(codec Codec, transformers ...Transformer) Codec {
+	return &chainCodec{codec: codec, transformers: transformers}
+}
+
+type chainCodec struct {
+	codec        Codec
+	transformers []Transformer
+}
+
+func (c *chainCodec) ContentType() string { return c.codec.ContentType() }
+
+func (c *chainCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range c.transformers {
+		if data, err = t.Encode(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name(), err)
+		}
+	}
+	return data, nil
+}
+
+func (c *chainCodec) Unmarshal(data []byte, v any) error {
+	var err error
+	for i := len(c.transformers) - 1; i >= 0; i-- {
+		t := c.transformers[i]
+		if data, err = t.Decode(data); err != nil {
+			return fmt.Errorf("%s: %w", t.Name(), err)
+		}
+	}
+	return c.codec.Unmarshal(data, v)
+}
+
+// pipeline names the codec and its transformers, e.g. `json+gzip`.
+func (c *chainCodec) pipeline() string {
+	names := []string{codecName(c.codec)}
+	for _, t := range c.transformers {
+		names = append(names, t.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+// codecName derives the name of a codec from the subtype of its content
+// type, e.g. `json` for `application/json`.
+func codecName(codec Codec) string {
+	name := codec.ContentType()
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexAny(name, "/+"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimPrefix(name, "x-")
+}
+
+// Gzip is a Transformer compressing payloads with gzip at level, e.g.
+// gzip.BestSpeed. The objects aren't stored with gzip content encoding, so
+// GCS never decompresses them on download.
+func Gzip(level int) Transformer { return gzipTransformer{level} }
+
+type gzipTransformer struct{ level int }
+
+func (t gzipTransformer) Name() string { return "gzip" }
+
+func (t gzipTransformer) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, t.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t gzipTransformer) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// AESGCM is a Transformer encrypting payloads with AES-GCM using key, which
+// must be 16, 24 or 32 bytes long. A random nonce is prepended to every
+// payload.
+func AESGCM(key []byte) (Transformer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCMTransformer{aead}, nil
+}
+
+type aesGCMTransformer struct{ aead cipher.AEAD }
+
+func (t aesGCMTransformer) Name() string { return "aes-gcm" }
+
+func (t aesGCMTransformer) Encode(data []byte) ([]byte, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return t.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (t aesGCMTransformer) Decode(data []byte) ([]byte, error) {
+	if len(data) < t.aead.NonceSize() {
+		return nil, errors.New("payload too short")
+	}
+	nonce, sealed := data[:t.aead.NonceSize()], data[t.aead.NonceSize():]
+	return t.aead.Open(nil, nonce, sealed, nil)
+}
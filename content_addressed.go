@@ -0,0 +1,382 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// contentAlias is the object stored under a logical key in a content
+// addressed store. It points to the object holding the actual payload.
+type contentAlias struct {
+	Hash string `json:"hash"`
+}
+
+// contentAddressedStore implements the CRUDStore interface by storing each
+// distinct payload once, named by its sha256 hash, and writing small alias
+// objects under the logical keys.
+type contentAddressedStore[T any] struct {
+	cs      *CloudStorage
+	aliases *querier[contentAlias]
+	prefix  string
+}
+
+// NewContentAddressedStore creates a deduplicating CRUDStore. Payloads are
+// stored under contentPrefix followed by their hex encoded sha256 hash, so
+// identical payloads written under many keys are only stored once.
+//
+// Deleting a key only removes its alias. Payloads which are no longer
+// referenced are left for IndexChecker.VerifyContent to clean up.
+//
+// The options configure the alias objects, while WithReadBandwidth and
+// WithRetryBudget also apply to payloads and Patch.
+func NewContentAddressedStore[T any](cs *CloudStorage, contentPrefix string, opts ...StoreOption) CRUDStore[T] {
+	return &contentAddressedStore[T]{
+		cs:      cs,
+		aliases: newQuerier[contentAlias](cs, opts...),
+		prefix:  contentPrefix,
+	}
+}
+
+// Create
+func (s *contentAddressedStore[T]) Create(ctx context.Context, key string, obj T) error {
+	hash, restore, err := s.writeContent(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	if err := s.aliases.Create(ctx, key, contentAlias{Hash: hash}); err != nil {
+		return err
+	}
+	if err := restore(ctx); err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get
+func (s *contentAddressedStore[T]) Get(ctx context.Context, key string) (*T, error) {
+	alias, err := s.aliases.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.readContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("Get %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// GetIfChanged compares generations of the alias object.
+func (s *contentAddressedStore[T]) GetIfChanged(ctx context.Context, key string, generation int64) (*T, int64, error) {
+	alias, generation, err := s.aliases.GetIfChanged(ctx, key, generation)
+	if err != nil {
+		return nil, generation, err
+	}
+	obj, err := s.readContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, generation, fmt.Errorf("GetIfChanged %s: %w", key, err)
+	}
+	return obj, generation, nil
+}
+
+// GetAsOf looks up the alias live at t. Payloads are immutable so the content
+// object is read as is.
+func (s *contentAddressedStore[T]) GetAsOf(ctx context.Context, key string, t time.Time) (*T, error) {
+	alias, err := s.aliases.GetAsOf(ctx, key, t)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.readContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("GetAsOf %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// GetField extracts the field from the payload the alias points at.
+func (s *contentAddressedStore[T]) GetField(ctx context.Context, key, pointer string) (json.RawMessage, error) {
+	alias, err := s.aliases.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := s.openContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("GetField %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	value, err := extractPointer(reader, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("GetField %s %s: %w", key, pointer, err)
+	}
+	return value, nil
+}
+
+// Put
+func (s *contentAddressedStore[T]) Put(ctx context.Context, key string, obj T) error {
+	hash, restore, err := s.writeContent(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	if err := s.aliases.Put(ctx, key, contentAlias{Hash: hash}); err != nil {
+		return err
+	}
+	if err := restore(ctx); err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Set
+func (s *contentAddressedStore[T]) Set(ctx context.Context, key string, obj T) error {
+	hash, restore, err := s.writeContent(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	if err := s.aliases.Set(ctx, key, contentAlias{Hash: hash}); err != nil {
+		return err
+	}
+	if err := restore(ctx); err != nil {
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	return nil
+}
+
+// Patch applies the merge patch to the payload and points the alias at the
+// patched payload, as long as the alias hasn't changed in the meantime.
+// Attempts racing with other writes are retried within the retry budget.
+func (s *contentAddressedStore[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	var obj *T
+	err := s.aliases.cfg.retries().run(ctx, func(ctx context.Context) (err error) {
+		obj, err = s.patch(ctx, key, patch)
+		return err
+	}, isPreconditionFailed)
+	if err != nil {
+		return nil, fmt.Errorf("Patch %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+func (s *contentAddressedStore[T]) patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	reader, err := s.aliases.open(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	var alias contentAlias
+	err = json.NewDecoder(reader).Decode(&alias)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := s.openContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(content)
+	content.Close()
+	if err != nil {
+		return nil, fmt.Errorf("content %s: %w", alias.Hash, err)
+	}
+
+	obj, _, err := applyPatch[T](jsonCodec{s.cs}, data, patch)
+	if err != nil {
+		return nil, err
+	}
+	hash, restore, err := s.writeContent(ctx, *obj)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := s.cs.Marshal(&contentAlias{Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	conds := storage.Conditions{GenerationMatch: reader.Attrs.Generation}
+	if err := s.cs.writeFileIf(ctx, key, bytes.NewReader(encoded), "application/json", conds); err != nil {
+		return nil, err
+	}
+	if err := restore(ctx); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Swap points the alias at the new payload and reads the payload the previous
+// alias pointed at.
+func (s *contentAddressedStore[T]) Swap(ctx context.Context, key string, obj T) (*T, error) {
+	hash, restore, err := s.writeContent(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("Swap %s: %w", key, err)
+	}
+	alias, err := s.aliases.Swap(ctx, key, contentAlias{Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	if err := restore(ctx); err != nil {
+		return nil, fmt.Errorf("Swap %s: %w", key, err)
+	}
+	if alias == nil {
+		return nil, nil
+	}
+	previous, err := s.readContent(ctx, alias.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("Swap %s: %w", key, err)
+	}
+	return previous, nil
+}
+
+// Delete
+func (s *contentAddressedStore[T]) Delete(ctx context.Context, key string) error {
+	return s.aliases.Delete(ctx, key)
+}
+
+// DeleteIfGeneration compares the generation of the alias object.
+func (s *contentAddressedStore[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) error {
+	return s.aliases.DeleteIfGeneration(ctx, key, generation)
+}
+
+// List iterates over the alias objects.
+func (s *contentAddressedStore[T]) List(ctx context.Context, prefix string) *storage.ObjectIterator {
+	return s.aliases.List(ctx, prefix)
+}
+
+// ListDelimited lists the immediate children among the alias objects.
+func (s *contentAddressedStore[T]) ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator {
+	return s.aliases.ListDelimited(ctx, prefix, delimiter)
+}
+
+// ListGlob lists the alias objects matching the glob.
+func (s *contentAddressedStore[T]) ListGlob(ctx context.Context, glob string) *storage.ObjectIterator {
+	return s.aliases.ListGlob(ctx, glob)
+}
+
+// ListRange lists the alias objects in the range.
+func (s *contentAddressedStore[T]) ListRange(ctx context.Context, start, end string) *storage.ObjectIterator {
+	return s.aliases.ListRange(ctx, start, end)
+}
+
+// GetAll
+func (s *contentAddressedStore[T]) GetAll(ctx context.Context, prefix string, progress ProgressFunc) ([]Entry[T], error) {
+	return getAll(ctx, s.cs, prefix, s.Get, storeConfig{}, progress)
+}
+
+// ForEach
+func (s *contentAddressedStore[T]) ForEach(ctx context.Context, prefix string, workers int, fn func(key string, obj *T) error) error {
+	if err := forEach(ctx, s.cs, prefix, workers, s.Get, fn); err != nil {
+		return fmt.Errorf("ForEach %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// DeleteAll deletes the aliases under prefix.
+func (s *contentAddressedStore[T]) DeleteAll(ctx context.Context, prefix string, progress ProgressFunc) error {
+	return s.aliases.DeleteAll(ctx, prefix, progress)
+}
+
+// openContent opens a reader on the payload stored under hash, holding an
+// operation slot of the CloudStorage while the download is running. Reads
+// are limited to the read bandwidth of the store.
+func (s *contentAddressedStore[T]) openContent(ctx context.Context, hash string) (openedReader, error) {
+	release, err := s.cs.acquire(ctx)
+	if err != nil {
+		return openedReader{}, err
+	}
+	reader, err := s.cs.newReader(ctx, s.cs.readObject(s.prefix+hash))
+	if err2 := wrapStorageError(err); err2 != nil {
+		release()
+		return openedReader{}, fmt.Errorf("content %s: %w", hash, err2)
+	}
+	return openedReader{reader, s.aliases.readLimit.reader(ctx, reader), release}, nil
+}
+
+// readContent decodes the payload stored under hash.
+func (s *contentAddressedStore[T]) readContent(ctx context.Context, hash string) (*T, error) {
+	reader, err := s.openContent(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var obj T
+	if err := json.NewDecoder(reader).Decode(&obj); err != nil {
+		return nil, fmt.Errorf("content %s: %w", hash, err)
+	}
+	return &obj, nil
+}
+
+// Prefetch is a no-op since the store doesn't cache.
+func (s *contentAddressedStore[T]) Prefetch(ctx context.Context, prefix string) error {
+	return nil
+}
+
+// writeContent stores the encoded obj under its hash unless it already exists.
+// The returned restore func must be called once the alias points at the hash:
+// a payload which was already stored may have been deleted as unreferenced by
+// VerifyContent before the alias was written, in which case restore writes it
+// again.
+func (s *contentAddressedStore[T]) writeContent(ctx context.Context, obj T) (string, func(context.Context) error, error) {
+	data, err := s.cs.Marshal(&obj)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	existed, err := s.putContent(ctx, hash, data)
+	if err != nil {
+		return "", nil, err
+	}
+	restore := func(ctx context.Context) error {
+		if !existed {
+			return nil
+		}
+		_, err := s.cs.objectAttrs(ctx, s.prefix+hash)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			_, err = s.putContent(ctx, hash, data)
+			return err
+		} else if err != nil {
+			return fmt.Errorf("content %s: Attrs: %w", hash, err)
+		}
+		return nil
+	}
+	return hash, restore, nil
+}
+
+// putContent writes data under hash unless it already exists, which is
+// reported as existed.
+func (s *contentAddressedStore[T]) putContent(ctx context.Context, hash string, data []byte) (existed bool, err error) {
+	o := s.cs.bucket.Object(s.prefix + hash).If(storage.Conditions{DoesNotExist: true})
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := s.cs.newWriter(cctx, o)
+	writer.ContentType = s.cs.contenttype
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		return false, fmt.Errorf("content %s: copy: %w", hash, err)
+	}
+	// a failed precondition means the payload is already stored
+	if err := writer.Close(); isPreconditionFailed(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("content %s: Close: %w", hash, err)
+	}
+	return false, nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
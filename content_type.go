@@ -0,0 +1,52 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// sniffLen is how many leading bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// WriteFileAs creates the object at key like WriteFile, using contentType
+// instead of the configured or sniffed content type. An empty contentType is
+// sniffed from the payload.
+func (cs *CloudStorage) WriteFileAs(ctx context.Context, key string, reader io.Reader, contentType string) error {
+	if contentType == "" {
+		var err error
+		if reader, contentType, err = sniffContentType(reader); err != nil {
+			return err
+		}
+	}
+	return cs.writeFile(ctx, key, reader, contentType)
+}
+
+// sniffContentType detects the content type of the payload in r from its
+// first bytes. The returned reader yields the whole payload and keeps the
+// size of r, if known.
+func sniffContentType(r io.Reader) (io.Reader, string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, "", err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	payload := io.MultiReader(bytes.NewReader(head), r)
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return sizedReader{payload, s.Size()}, contentType, nil
+	}
+	return payload, contentType, nil
+}
+
+// sizedReader reports the total size of a payload split across readers.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (r sizedReader) Size() int64 { return r.size }
@@ -0,0 +1,67 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"cloud.google.com/go/storage"
+)
+
+// CORS returns the CORS policy of the bucket.
+func (cs *CloudStorage) CORS(ctx context.Context) ([]storage.CORS, error) {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("CORS: %w", err)
+	}
+	return attrs.CORS, nil
+}
+
+// SetCORS replaces the CORS policy of the bucket. An empty policy removes it.
+func (cs *CloudStorage) SetCORS(ctx context.Context, rules []storage.CORS) error {
+	if rules == nil {
+		rules = []storage.CORS{}
+	}
+	_, err := cs.bucket.Update(ctx, storage.BucketAttrsToUpdate{CORS: rules})
+	if err != nil {
+		return fmt.Errorf("SetCORS: %w", err)
+	}
+	return nil
+}
+
+// EnsureCORS adds the rules missing from the CORS policy of the bucket, e.g.
+// at startup of services handing out signed URLs to browsers. Other rules are
+// left as is.
+func (cs *CloudStorage) EnsureCORS(ctx context.Context, rules ...storage.CORS) error {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("EnsureCORS: %w", err)
+	}
+
+	policy := attrs.CORS
+	for _, rule := range rules {
+		if !containsCORS(policy, rule) {
+			policy = append(policy, rule)
+		}
+	}
+	if len(policy) == len(attrs.CORS) {
+		return nil
+	}
+
+	// only replace the policy we just read so concurrent updates aren't lost
+	_, err = cs.bucket.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).
+		Update(ctx, storage.BucketAttrsToUpdate{CORS: policy})
+	if err != nil {
+		return fmt.Errorf("EnsureCORS: %w", err)
+	}
+	return nil
+}
+
+func containsCORS(policy []storage.CORS, rule storage.CORS) bool {
+	for _, r := range policy {
+		if reflect.DeepEqual(r, rule) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ErrInvalidCursor is returned for cursors that weren't issued by Cursors
+// with the same key, were modified, or were issued for another prefix.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorVersion is the first byte of every cursor, to be able to change the
+// encoding without misreading cursors already handed out.
+const cursorVersion = 1
+
+// Cursors encodes the page tokens of listings into opaque cursors, signed
+// with HMAC-SHA256 so they can be handed to clients, e.g. by HTTP APIs,
+// without exposing GCS page tokens or letting clients tamper with them.
+//
+// Key returns the HMAC key and PreviousKeys are accepted when decoding, like
+// for SigningCodec.
+type Cursors struct {
+	Key          func() ([]byte, error)
+	PreviousKeys [][]byte
+}
+
+// Encode returns the cursor continuing the listing of prefix at pageToken.
+func (c *Cursors) Encode(prefix, pageToken string) (string, error) {
+	key, err := c.Key()
+	if err != nil {
+		return "", fmt.Errorf("cursor key: %w", err)
+	}
+	payload := []byte{cursorVersion}
+	payload = binary.AppendUvarint(payload, uint64(len(prefix)))
+	payload = append(payload, prefix...)
+	payload = append(payload, pageToken...)
+	return base64.RawURLEncoding.EncodeToString(append(payload, sign(key, payload)...)), nil
+}
+
+// Decode returns the page token of cursor, failing with ErrInvalidCursor if
+// it wasn't issued for the listing of prefix.
+func (c *Cursors) Decode(cursor, prefix string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) < 1+sha256.Size {
+		return "", ErrInvalidCursor
+	}
+	payload, signature := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	key, err := c.Key()
+	if err != nil {
+		return "", fmt.Errorf("cursor key: %w", err)
+	}
+	valid := hmac.Equal(signature, sign(key, payload))
+	for _, previous := range c.PreviousKeys {
+		valid = valid || hmac.Equal(signature, sign(previous, payload))
+	}
+	if !valid || payload[0] != cursorVersion {
+		return "", ErrInvalidCursor
+	}
+
+	rest := payload[1:]
+	n, size := binary.Uvarint(rest)
+	if size <= 0 || uint64(len(rest)-size) < n {
+		return "", ErrInvalidCursor
+	}
+	rest = rest[size:]
+	if !bytes.Equal(rest[:n], []byte(prefix)) {
+		return "", fmt.Errorf("%w: issued for another prefix", ErrInvalidCursor)
+	}
+	return string(rest[n:]), nil
+}
+
+// Page returns up to size objects of the listing of prefix by it, starting at
+// cursor, and the cursor of the next page. The first page has an empty
+// cursor, and the last page an empty next cursor.
+func (c *Cursors) Page(it *storage.ObjectIterator, prefix, cursor string, size int) ([]*storage.ObjectAttrs, string, error) {
+	var token string
+	if cursor != "" {
+		var err error
+		if token, err = c.Decode(cursor, prefix); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var page []*storage.ObjectAttrs
+	next, err := iterator.NewPager(it, size, token).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+	if next == "" {
+		return page, "", nil
+	}
+	next, err = c.Encode(prefix, next)
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
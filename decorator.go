@@ -0,0 +1,371 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned by writes to a store decorated with ReadOnly.
+var ErrReadOnly = errors.New("store is read-only")
+
+// Decorator wraps a CRUDStore to add cross-cutting behavior. Decorators
+// embed the store they wrap, so operations they don't override are passed
+// through unchanged.
+type Decorator[T any] func(CRUDStore[T]) CRUDStore[T]
+
+// Chain wraps base with decorators. The first decorator is the outermost,
+// seeing every call first, e.g.
+//
+//	Chain(base, Metered[T](observe), Cached[T](NewMemoryCache(), time.Minute), Retried[T](budget))
+//
+// measures calls including cache hits and only retries cache misses.
+func Chain[T any](base CRUDStore[T], decorators ...Decorator[T]) CRUDStore[T] {
+	store := base
+	for i := len(decorators) - 1; i >= 0; i-- {
+		store = decorators[i](store)
+	}
+	return store
+}
+
+// Cached serves Gets from cache for ttl after the object was read. Objects
+// are stored JSON encoded, so caches can be shared between processes. Writes
+// through the decorated store evict the key, writes by others are only seen
+// once the entry expires, or never with a ttl <= 0. Failing cache operations
+// fall back to the store.
+func Cached[T any](cache Cache, ttl time.Duration) Decorator[T] {
+	return func(store CRUDStore[T]) CRUDStore[T] {
+		return &cachedStore[T]{CRUDStore: store, cache: cache, ttl: ttl}
+	}
+}
+
+type cachedStore[T any] struct {
+	CRUDStore[T]
+	cache Cache
+	ttl   time.Duration
+
+	mu sync.Mutex
+	// evictions is bumped on every evict so Gets racing with a write
+	// don't cache what they read before it
+	evictions uint64
+}
+
+func (s *cachedStore[T]) Get(ctx context.Context, key string) (*T, error) {
+	if data, ok, err := s.cache.Get(ctx, key); err == nil && ok {
+		var obj T
+		if json.Unmarshal(data, &obj) == nil {
+			return &obj, nil
+		}
+	}
+
+	evictions := s.epoch()
+	obj, err := s.CRUDStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(obj); err == nil && s.epoch() == evictions {
+		_ = s.cache.Set(ctx, key, data, s.ttl)
+		// an evict between the check and Set may have deleted the key
+		// before Set stored the stale object
+		if s.epoch() != evictions {
+			_ = s.cache.Delete(ctx, key)
+		}
+	}
+	return obj, nil
+}
+
+func (s *cachedStore[T]) epoch() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evictions
+}
+
+func (s *cachedStore[T]) evict(ctx context.Context, key string) {
+	s.mu.Lock()
+	s.evictions++
+	s.mu.Unlock()
+	_ = s.cache.Delete(ctx, key)
+}
+
+func (s *cachedStore[T]) Create(ctx context.Context, key string, obj T) error {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Create(ctx, key, obj)
+}
+
+func (s *cachedStore[T]) Put(ctx context.Context, key string, obj T) error {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Put(ctx, key, obj)
+}
+
+func (s *cachedStore[T]) Set(ctx context.Context, key string, obj T) error {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Set(ctx, key, obj)
+}
+
+func (s *cachedStore[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Patch(ctx, key, patch)
+}
+
+func (s *cachedStore[T]) Swap(ctx context.Context, key string, obj T) (*T, error) {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Swap(ctx, key, obj)
+}
+
+func (s *cachedStore[T]) Delete(ctx context.Context, key string) error {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.Delete(ctx, key)
+}
+
+func (s *cachedStore[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) error {
+	defer s.evict(ctx, key)
+	return s.CRUDStore.DeleteIfGeneration(ctx, key, generation)
+}
+
+// DeleteAll evicts every key as it is deleted.
+func (s *cachedStore[T]) DeleteAll(ctx context.Context, prefix string, progress ProgressFunc) error {
+	return s.CRUDStore.DeleteAll(ctx, prefix, func(done, total int, lastKey string) {
+		s.evict(ctx, lastKey)
+		if progress != nil {
+			progress(done, total, lastKey)
+		}
+	})
+}
+
+// Metered calls observe with the duration and result of every single-object
+// operation, e.g. to record latency histograms and error rates.
+func Metered[T any](observe func(op string, elapsed time.Duration, err error)) Decorator[T] {
+	return func(store CRUDStore[T]) CRUDStore[T] {
+		return &meteredStore[T]{CRUDStore: store, observe: observe}
+	}
+}
+
+type meteredStore[T any] struct {
+	CRUDStore[T]
+	observe func(string, time.Duration, error)
+}
+
+// measure starts timing op. The returned func reports it with the error err
+// points at.
+func (s *meteredStore[T]) measure(op string) func(err *error) {
+	start := time.Now()
+	return func(err *error) { s.observe(op, time.Since(start), *err) }
+}
+
+func (s *meteredStore[T]) Create(ctx context.Context, key string, obj T) (err error) {
+	defer s.measure("Create")(&err)
+	return s.CRUDStore.Create(ctx, key, obj)
+}
+
+func (s *meteredStore[T]) Get(ctx context.Context, key string) (_ *T, err error) {
+	defer s.measure("Get")(&err)
+	return s.CRUDStore.Get(ctx, key)
+}
+
+func (s *meteredStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (_ *T, _ bool, err error) {
+	defer s.measure("GetOrCreate")(&err)
+	return s.CRUDStore.GetOrCreate(ctx, key, factory)
+}
+
+func (s *meteredStore[T]) GetEntry(ctx context.Context, key string) (_ *Entry[T], err error) {
+	defer s.measure("GetEntry")(&err)
+	return s.CRUDStore.GetEntry(ctx, key)
+}
+
+func (s *meteredStore[T]) GetIfChanged(ctx context.Context, key string, generation int64) (_ *T, _ int64, err error) {
+	defer s.measure("GetIfChanged")(&err)
+	return s.CRUDStore.GetIfChanged(ctx, key, generation)
+}
+
+func (s *meteredStore[T]) GetAsOf(ctx context.Context, key string, t time.Time) (_ *T, err error) {
+	defer s.measure("GetAsOf")(&err)
+	return s.CRUDStore.GetAsOf(ctx, key, t)
+}
+
+func (s *meteredStore[T]) GetField(ctx context.Context, key, pointer string) (_ json.RawMessage, err error) {
+	defer s.measure("GetField")(&err)
+	return s.CRUDStore.GetField(ctx, key, pointer)
+}
+
+func (s *meteredStore[T]) Put(ctx context.Context, key string, obj T) (err error) {
+	defer s.measure("Put")(&err)
+	return s.CRUDStore.Put(ctx, key, obj)
+}
+
+func (s *meteredStore[T]) Set(ctx context.Context, key string, obj T) (err error) {
+	defer s.measure("Set")(&err)
+	return s.CRUDStore.Set(ctx, key, obj)
+}
+
+func (s *meteredStore[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (_ *T, err error) {
+	defer s.measure("Patch")(&err)
+	return s.CRUDStore.Patch(ctx, key, patch)
+}
+
+func (s *meteredStore[T]) Swap(ctx context.Context, key string, obj T) (_ *T, err error) {
+	defer s.measure("Swap")(&err)
+	return s.CRUDStore.Swap(ctx, key, obj)
+}
+
+func (s *meteredStore[T]) Delete(ctx context.Context, key string) (err error) {
+	defer s.measure("Delete")(&err)
+	return s.CRUDStore.Delete(ctx, key)
+}
+
+func (s *meteredStore[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) (err error) {
+	defer s.measure("DeleteIfGeneration")(&err)
+	return s.CRUDStore.DeleteIfGeneration(ctx, key, generation)
+}
+
+// Retried retries reads, Puts and Deletes failing with transient errors, see
+// IsTransient, within budget. Creates are not retried since an attempt
+// failing after the object was written would make the retry fail.
+func Retried[T any](budget RetryBudget) Decorator[T] {
+	return func(store CRUDStore[T]) CRUDStore[T] {
+		return &retriedStore[T]{CRUDStore: store, budget: budget}
+	}
+}
+
+type retriedStore[T any] struct {
+	CRUDStore[T]
+	budget RetryBudget
+}
+
+func (s *retriedStore[T]) Get(ctx context.Context, key string) (obj *T, err error) {
+	err = s.budget.run(ctx, func(ctx context.Context) error {
+		obj, err = s.CRUDStore.Get(ctx, key)
+		return err
+	}, IsTransient)
+	return obj, err
+}
+
+func (s *retriedStore[T]) GetIfChanged(ctx context.Context, key string, generation int64) (obj *T, current int64, err error) {
+	err = s.budget.run(ctx, func(ctx context.Context) error {
+		obj, current, err = s.CRUDStore.GetIfChanged(ctx, key, generation)
+		return err
+	}, IsTransient)
+	return obj, current, err
+}
+
+func (s *retriedStore[T]) GetEntry(ctx context.Context, key string) (entry *Entry[T], err error) {
+	err = s.budget.run(ctx, func(ctx context.Context) error {
+		entry, err = s.CRUDStore.GetEntry(ctx, key)
+		return err
+	}, IsTransient)
+	return entry, err
+}
+
+func (s *retriedStore[T]) Put(ctx context.Context, key string, obj T) error {
+	return s.budget.run(ctx, func(ctx context.Context) error {
+		return s.CRUDStore.Put(ctx, key, obj)
+	}, IsTransient)
+}
+
+func (s *retriedStore[T]) Set(ctx context.Context, key string, obj T) error {
+	return s.budget.run(ctx, func(ctx context.Context) error {
+		return s.CRUDStore.Set(ctx, key, obj)
+	}, IsTransient)
+}
+
+func (s *retriedStore[T]) Delete(ctx context.Context, key string) error {
+	return s.budget.run(ctx, func(ctx context.Context) error {
+		return s.CRUDStore.Delete(ctx, key)
+	}, IsTransient)
+}
+
+// Audited calls record after every write with the operation, key and result,
+// e.g. to keep an audit log of who changed what.
+func Audited[T any](record func(op, key string, err error)) Decorator[T] {
+	return func(store CRUDStore[T]) CRUDStore[T] {
+		return &auditedStore[T]{CRUDStore: store, record: record}
+	}
+}
+
+type auditedStore[T any] struct {
+	CRUDStore[T]
+	record func(string, string, error)
+}
+
+func (s *auditedStore[T]) Create(ctx context.Context, key string, obj T) (err error) {
+	defer func() { s.record("Create", key, err) }()
+	return s.CRUDStore.Create(ctx, key, obj)
+}
+
+// GetOrCreate creates through Create, so created objects are recorded.
+func (s *auditedStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	return getOrCreate(ctx, key, s.Get, s.Create, factory)
+}
+
+func (s *auditedStore[T]) Put(ctx context.Context, key string, obj T) (err error) {
+	defer func() { s.record("Put", key, err) }()
+	return s.CRUDStore.Put(ctx, key, obj)
+}
+
+func (s *auditedStore[T]) Set(ctx context.Context, key string, obj T) (err error) {
+	defer func() { s.record("Set", key, err) }()
+	return s.CRUDStore.Set(ctx, key, obj)
+}
+
+func (s *auditedStore[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (_ *T, err error) {
+	defer func() { s.record("Patch", key, err) }()
+	return s.CRUDStore.Patch(ctx, key, patch)
+}
+
+func (s *auditedStore[T]) Swap(ctx context.Context, key string, obj T) (_ *T, err error) {
+	defer func() { s.record("Swap", key, err) }()
+	return s.CRUDStore.Swap(ctx, key, obj)
+}
+
+func (s *auditedStore[T]) Delete(ctx context.Context, key string) (err error) {
+	defer func() { s.record("Delete", key, err) }()
+	return s.CRUDStore.Delete(ctx, key)
+}
+
+func (s *auditedStore[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) (err error) {
+	defer func() { s.record("DeleteIfGeneration", key, err) }()
+	return s.CRUDStore.DeleteIfGeneration(ctx, key, generation)
+}
+
+func (s *auditedStore[T]) DeleteAll(ctx context.Context, prefix string, progress ProgressFunc) (err error) {
+	defer func() { s.record("DeleteAll", prefix, err) }()
+	return s.CRUDStore.DeleteAll(ctx, prefix, progress)
+}
+
+// ReadOnly rejects every write with ErrReadOnly, e.g. for stores shared with
+// reporting jobs.
+func ReadOnly[T any]() Decorator[T] {
+	return func(store CRUDStore[T]) CRUDStore[T] {
+		return &readOnlyStore[T]{CRUDStore: store}
+	}
+}
+
+type readOnlyStore[T any] struct {
+	CRUDStore[T]
+}
+
+func (s *readOnlyStore[T]) Create(context.Context, string, T) error { return ErrReadOnly }
+func (s *readOnlyStore[T]) Put(context.Context, string, T) error    { return ErrReadOnly }
+func (s *readOnlyStore[T]) Set(context.Context, string, T) error    { return ErrReadOnly }
+func (s *readOnlyStore[T]) Delete(context.Context, string) error    { return ErrReadOnly }
+
+func (s *readOnlyStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	return getOrCreate(ctx, key, s.Get, s.Create, factory)
+}
+
+func (s *readOnlyStore[T]) Patch(context.Context, string, json.RawMessage) (*T, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *readOnlyStore[T]) Swap(context.Context, string, T) (*T, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *readOnlyStore[T]) DeleteIfGeneration(context.Context, string, int64) error {
+	return ErrReadOnly
+}
+
+func (s *readOnlyStore[T]) DeleteAll(context.Context, string, ProgressFunc) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,66 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+)
+
+// GetEntry decodes the object at key together with its timestamps,
+// generation and size. The attributes always belong to the decoded generation.
+func (q *querier[T]) GetEntry(ctx context.Context, key string) (_ *Entry[T], err error) {
+	defer q.cs.finish("GetEntry", key, &err)
+
+	o := q.cs.readObject(q.cs.Filename(key))
+	attrs, err := o.Attrs(ctx)
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err2)
+	}
+
+	release, err := q.cs.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err)
+	}
+	reader, err := q.cs.newReader(ctx, o.Generation(attrs.Generation))
+	if err2 := wrapStorageError(err); err2 != nil {
+		release()
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err2)
+	}
+	data, err := ioutil.ReadAll(q.readLimit.reader(ctx, reader))
+	reader.Close()
+	release()
+	if err != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err)
+	}
+	if err := q.verify(attrs.Metadata, data); err != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err)
+	}
+
+	obj, err := q.decode(ctx, key, data, attrs.Generation)
+	if err != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err)
+	}
+	entry := newEntry(key, obj, attrs)
+	return &entry, nil
+}
+
+// GetEntry returns the attributes of the alias object together with the
+// payload it points at.
+func (s *contentAddressedStore[T]) GetEntry(ctx context.Context, key string) (*Entry[T], error) {
+	entry, err := s.aliases.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.readContent(ctx, entry.Value.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("GetEntry %s: %w", key, err)
+	}
+	return &Entry[T]{
+		Key:        key,
+		Value:      obj,
+		Created:    entry.Created,
+		Updated:    entry.Updated,
+		Generation: entry.Generation,
+		Size:       entry.Size,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package objectstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"google.golang.org/api/googleapi"
+)
+
+// IsTransient reports whether err is a temporary failure that may go away by
+// repeating the same request, such as rate limiting (429), server errors
+// (5xx), timeouts and reset connections.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusRequestTimeout || gerr.Code >= 500
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsRetryable reports whether retrying the operation may succeed. Besides
+// transient failures this includes precondition failures, which succeed once
+// the object is read again and the change is reapplied.
+func IsRetryable(err error) bool {
+	return IsTransient(err) || errors.Is(err, ErrGenerationMismatch) || isPreconditionFailed(err)
+}
+
+// StorageOpError is wrapped around the errors of the operations of the
+// stores and CloudStorage, so logging and metrics can extract the operation,
+// bucket and key with errors.As instead of parsing messages. Errors of
+// failed requests to Cloud Storage also carry the details of the response,
+// e.g. to quote in a support ticket.
+type StorageOpError struct {
+	Op     string
+	Bucket string
+	Key    string
+
+	// StatusCode, Reason and RequestID are taken from the API response, and
+	// are zero for other errors.
+	StatusCode int
+	Reason     string
+	RequestID  string
+
+	Err error
+}
+
+// Error is the message of Err, which already names the operation and key,
+// followed by the details of the API response if any.
+func (e *StorageOpError) Error() string {
+	if e.StatusCode == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s (status %d, reason %q, request id %q)", e.Err, e.StatusCode, e.Reason, e.RequestID)
+}
+
+func (e *StorageOpError) Unwrap() error {
+	return e.Err
+}
+
+// withDetails wraps API errors in a StorageOpError. Other errors are returned
+// as is.
+func withDetails(op, key string, err error) error {
+	var gerr *googleapi.Error
+	var operr *StorageOpError
+	if !errors.As(err, &gerr) || errors.As(err, &operr) {
+		return err
+	}
+
+	operr = &StorageOpError{
+		Op:         op,
+		Key:        key,
+		StatusCode: gerr.Code,
+		RequestID:  gerr.Header.Get("X-GUploader-UploadID"),
+		Err:        err,
+	}
+	if len(gerr.Errors) > 0 {
+		operr.Reason = gerr.Errors[0].Reason
+	}
+	return operr
+}
+
+// finish wraps the error of the operation in a StorageOpError, unless it
+// already carries one from withDetails, and reports it to the error observer.
+// Errors finished by a nested operation are left as is, so they are only
+// reported once. Deferred with a pointer to the named error result.
+func (cs *CloudStorage) finish(op, key string, errp *error) {
+	var operr *StorageOpError
+	if *errp == nil {
+		return
+	} else if !errors.As(*errp, &operr) {
+		*errp = &StorageOpError{Op: op, Key: key, Err: *errp}
+		operr = (*errp).(*StorageOpError)
+	} else if operr.Bucket != "" {
+		return
+	}
+	operr.Bucket = cs.bucketname
+	cs.observe(op, key, *errp)
+}
+
+// observe reports err to the error observer, if any.
+func (cs *CloudStorage) observe(op, key string, err error) {
+	if err == nil || cs.errorobserver == nil ||
+		errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrNotModified) {
+		return
+	}
+	cs.errorobserver(op, key, err)
+}
@@ -0,0 +1,128 @@
+package objectstore_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/lingio/objectstore"
+	"github.com/lingio/objectstore/objectstoretest"
+)
+
+type document struct {
+	Name string `json:"name"`
+}
+
+func newStore(t *testing.T) (*objectstore.CloudStorage, objectstore.CRUDStore[document]) {
+	t.Helper()
+	cs, _ := objectstoretest.NewCloudStorage(t, "bucket")
+	return cs, objectstore.NewCRUDStore[document](cs)
+}
+
+func TestMissingObjectErrors(t *testing.T) {
+	ctx := context.Background()
+	cs, store := newStore(t)
+
+	ops := map[string]func() error{
+		"Get": func() error {
+			_, err := store.Get(ctx, "missing")
+			return err
+		},
+		"GetEntry": func() error {
+			_, err := store.GetEntry(ctx, "missing")
+			return err
+		},
+		"GetFile": func() error {
+			_, err := cs.GetFile(ctx, "missing")
+			return err
+		},
+		"Delete": func() error {
+			return store.Delete(ctx, "missing")
+		},
+		"DeleteIfGeneration": func() error {
+			return store.DeleteIfGeneration(ctx, "missing", 1)
+		},
+		"UpdateAttrs": func() error {
+			_, err := cs.UpdateAttrs(ctx, "missing", func(*storage.ObjectAttrs) storage.ObjectAttrsToUpdate {
+				return storage.ObjectAttrsToUpdate{ContentType: "text/plain"}
+			})
+			return err
+		},
+	}
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, objectstore.ErrObjectNotFound) {
+			t.Errorf("%s: got %v, want ErrObjectNotFound", name, err)
+		}
+	}
+}
+
+func TestExistingObjectErrors(t *testing.T) {
+	ctx := context.Background()
+	cs, store := newStore(t)
+
+	if err := store.Create(ctx, "existing", document{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(ctx, "existing", document{Name: "b"}); !errors.Is(err, objectstore.ErrObjectExists) {
+		t.Errorf("Create: got %v, want ErrObjectExists", err)
+	}
+
+	if err := cs.WriteFile(ctx, "file", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.WriteFile(ctx, "file", strings.NewReader("b")); !errors.Is(err, objectstore.ErrObjectExists) {
+		t.Errorf("WriteFile: got %v, want ErrObjectExists", err)
+	}
+}
+
+func TestGenerationMismatchErrors(t *testing.T) {
+	ctx := context.Background()
+	cs, store := newStore(t)
+
+	if err := store.Put(ctx, "key", document{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	_, generation, err := store.GetIfChanged(ctx, "key", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "key", document{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteIfGeneration(ctx, "key", generation); !errors.Is(err, objectstore.ErrGenerationMismatch) {
+		t.Errorf("DeleteIfGeneration: got %v, want ErrGenerationMismatch", err)
+	}
+	if err := store.DeleteIfGeneration(ctx, "key", 0); !errors.Is(err, objectstore.ErrInvalidGeneration) {
+		t.Errorf("DeleteIfGeneration(0): got %v, want ErrInvalidGeneration", err)
+	}
+
+	// a concurrent update of the metadata between reading and updating it
+	_, err = cs.UpdateAttrs(ctx, "key", func(*storage.ObjectAttrs) storage.ObjectAttrsToUpdate {
+		if err := cs.Touch(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		return storage.ObjectAttrsToUpdate{ContentType: "text/plain"}
+	})
+	if !errors.Is(err, objectstore.ErrGenerationMismatch) {
+		t.Errorf("UpdateAttrs: got %v, want ErrGenerationMismatch", err)
+	}
+}
+
+func TestWritesCreateMissingObjects(t *testing.T) {
+	ctx := context.Background()
+	_, store := newStore(t)
+
+	if err := store.Put(ctx, "put", document{Name: "a"}); err != nil {
+		t.Errorf("Put: got %v, want nil", err)
+	}
+	if err := store.Set(ctx, "set", document{Name: "a"}); err != nil {
+		t.Errorf("Set: got %v, want nil", err)
+	}
+	for _, key := range []string{"put", "set"} {
+		if obj, err := store.Get(ctx, key); err != nil || obj.Name != "a" {
+			t.Errorf("Get %s: got %v, %v", key, obj, err)
+		}
+	}
+}
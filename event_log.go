@@ -0,0 +1,183 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Record is an event in an EventLog.
+type Record[T any] struct {
+	Seq   uint64
+	Value T
+}
+
+// eventHead points at the latest appended sequence number.
+type eventHead struct {
+	Seq uint64 `json:"seq"`
+}
+
+// EventLog is an append-only log storing each record as an individually
+// numbered object under a stream prefix.
+//
+// Sequence numbers are claimed by creating the record object with a
+// does-not-exist precondition, so concurrent appenders never share a number
+// and there are no gaps. A head object, updated with generation-matched
+// writes, points at the latest sequence so appends don't have to list.
+type EventLog[T any] struct {
+	cs       *CloudStorage
+	records  *querier[T]
+	heads    *querier[eventHead]
+	prefix   string
+	interval time.Duration
+}
+
+// NewEventLog creates an EventLog over the stream prefix, e.g. `events/orders/`.
+// pollInterval is how often Tail checks for new records.
+func NewEventLog[T any](cs *CloudStorage, prefix string, pollInterval time.Duration) *EventLog[T] {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &EventLog[T]{
+		cs:       cs,
+		records:  newQuerier[T](cs),
+		heads:    newQuerier[eventHead](cs),
+		prefix:   prefix,
+		interval: pollInterval,
+	}
+}
+
+// Append adds record to the log and returns its sequence number.
+func (l *EventLog[T]) Append(ctx context.Context, record T) (uint64, error) {
+	head, generation, err := l.heads.GetIfChanged(ctx, l.headKey(), 0)
+	if errors.Is(err, ErrObjectNotFound) {
+		head, generation = &eventHead{}, 0
+	} else if err != nil {
+		return 0, fmt.Errorf("Append: head: %w", err)
+	}
+
+	seq := head.Seq + 1
+	for {
+		err := l.records.Create(ctx, l.recordKey(seq), record)
+		if err == nil {
+			break
+		} else if !isPreconditionFailed(err) {
+			return 0, fmt.Errorf("Append %d: %w", seq, err)
+		}
+		// someone else claimed seq, the head is behind
+		seq++
+	}
+
+	if err := l.advanceHead(ctx, seq, generation); err != nil {
+		return seq, fmt.Errorf("Append %d: head: %w", seq, err)
+	}
+	return seq, nil
+}
+
+// advanceHead moves the head to seq unless it already is past it.
+func (l *EventLog[T]) advanceHead(ctx context.Context, seq uint64, generation int64) error {
+	for {
+		data, err := l.cs.Marshal(&eventHead{Seq: seq})
+		if err != nil {
+			return err
+		}
+		conds := storage.Conditions{GenerationMatch: generation}
+		if generation == 0 {
+			conds = storage.Conditions{DoesNotExist: true}
+		}
+		err = l.cs.writeFileIf(ctx, l.headKey(), bytes.NewReader(data), "application/json", conds)
+		if err == nil || !isPreconditionFailed(err) {
+			return err
+		}
+
+		// lost the race, check whether the winner moved the head far enough
+		head, g, err := l.heads.GetIfChanged(ctx, l.headKey(), 0)
+		if err != nil {
+			return err
+		}
+		if head.Seq >= seq {
+			return nil
+		}
+		generation = g
+	}
+}
+
+// ReadFrom returns all records with a sequence number of at least seq.
+func (l *EventLog[T]) ReadFrom(ctx context.Context, seq uint64) ([]Record[T], error) {
+	query := &storage.Query{
+		Prefix:      l.cs.FilenamePrefix(l.prefix),
+		StartOffset: l.cs.Filename(l.recordKey(seq)),
+	}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, fmt.Errorf("ReadFrom %d: %w", seq, err)
+	}
+
+	var records []Record[T]
+	it := l.cs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return records, fmt.Errorf("ReadFrom %d: %w", seq, err)
+		}
+
+		key, ok := l.cs.Key(attrs.Name)
+		if !ok || !strings.HasPrefix(key, l.prefix) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(key, l.prefix), 10, 64)
+		if err != nil {
+			continue // the head or a foreign object
+		}
+
+		value, err := l.records.Get(ctx, key)
+		if err != nil {
+			return records, fmt.Errorf("ReadFrom %d: %w", seq, err)
+		}
+		records = append(records, Record[T]{Seq: n, Value: *value})
+	}
+	return records, nil
+}
+
+// Tail calls fn for every record from seq onwards, polling for new records
+// until ctx is done or fn returns an error.
+func (l *EventLog[T]) Tail(ctx context.Context, seq uint64, fn func(Record[T]) error) error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		records, err := l.ReadFrom(ctx, seq)
+		if err != nil {
+			return fmt.Errorf("Tail: %w", err)
+		}
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return err
+			}
+			seq = record.Seq + 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *EventLog[T]) headKey() string {
+	return l.prefix + "head"
+}
+
+// recordKey zero pads seq so records list in sequence order.
+func (l *EventLog[T]) recordKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", l.prefix, seq)
+}
@@ -0,0 +1,140 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ErrFieldNotFound is returned by GetField if the JSON pointer doesn't resolve.
+var ErrFieldNotFound = errors.New("field not found")
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// GetField decodes only the value at the RFC 6901 JSON pointer, e.g.
+// `/address/city`, of the object at key. The rest of the document is skipped
+// while streaming it, avoiding decoding large documents in full. WithIntegrityCheck
+// the rest is still downloaded to verify it.
+func (q *querier[T]) GetField(ctx context.Context, key, pointer string) (_ json.RawMessage, err error) {
+	defer q.cs.finish("GetField", key, &err)
+
+	reader, err := q.open(ctx, key, 0)
+	if err != nil {
+		return nil, fmt.Errorf("GetField %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	var r io.Reader = reader
+	if _, ok := q.codec.(jsonCodec); !ok {
+		// only JSON can be streamed, other codecs are decoded in full
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("GetField %s: %w", key, err)
+		}
+		document, err := q.document(data)
+		if err != nil {
+			return nil, fmt.Errorf("GetField %s: %w", key, err)
+		}
+		r = bytes.NewReader(document)
+	}
+
+	value, err := extractPointer(r, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("GetField %s %s: %w", key, pointer, err)
+	}
+	if q.cfg.integrityCheck {
+		// the payload is only verified once it was read to the end
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			return nil, fmt.Errorf("GetField %s: %w", key, err)
+		}
+	}
+	return value, nil
+}
+
+// extractPointer streams the JSON document from r and decodes the value at pointer.
+func extractPointer(r io.Reader, pointer string) (json.RawMessage, error) {
+	if pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	var refs []string
+	if pointer != "" {
+		refs = strings.Split(pointer[1:], "/")
+	}
+
+	dec := json.NewDecoder(r)
+	for _, ref := range refs {
+		if err := seek(dec, pointerUnescaper.Replace(ref)); err != nil {
+			return nil, err
+		}
+	}
+
+	var value json.RawMessage
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// seek advances dec to the member or element ref of the next value.
+func seek(dec *json.Decoder, ref string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if name == ref {
+				return nil
+			}
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		index, err := strconv.Atoi(ref)
+		if err != nil {
+			return ErrFieldNotFound
+		}
+		for i := 0; dec.More(); i++ {
+			if i == index {
+				return nil
+			}
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+		}
+	}
+	return ErrFieldNotFound
+}
+
+// skipValue consumes the next value without decoding it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/iterator"
+)
+
+// ForEach calls fn with every object under prefix, fetching and decoding
+// them with workers goroutines while the prefix is still being listed. The
+// first error of fn, a fetch or the listing cancels the remaining work and is
+// returned; see CollectErrors to process every object instead. Objects
+// deleted while the operation is running are skipped. fn is called
+// concurrently.
+func (q *querier[T]) ForEach(ctx context.Context, prefix string, workers int, fn func(key string, obj *T) error) (err error) {
+	defer q.cs.finish("ForEach", prefix, &err)
+
+	if err := forEach(ctx, q.cs, prefix, workers, q.Get, fn); err != nil {
+		return fmt.Errorf("ForEach %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// forEachSeq is ForEach numbering the objects in listing order from zero.
+// fn is called with nil for objects deleted since they were listed, so that
+// every number is seen.
+func (q *querier[T]) forEachSeq(ctx context.Context, prefix string, workers int, fn func(seq int, key string, obj *T) error) error {
+	if err := forEachSeq(ctx, q.cs, prefix, workers, q.Get, fn); err != nil {
+		return fmt.Errorf("ForEach %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func forEach[T any](
+	ctx context.Context,
+	cs *CloudStorage,
+	prefix string,
+	workers int,
+	get func(context.Context, string) (*T, error),
+	fn func(string, *T) error,
+) error {
+	return forEachSeq(ctx, cs, prefix, workers, get, func(_ int, key string, obj *T) error {
+		if obj == nil {
+			return nil
+		}
+		return fn(key, obj)
+	})
+}
+
+type listedKey struct {
+	seq int
+	key string
+}
+
+func forEachSeq[T any](
+	ctx context.Context,
+	cs *CloudStorage,
+	prefix string,
+	workers int,
+	get func(context.Context, string) (*T, error),
+	fn func(int, string, *T) error,
+) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var failure error
+	fail := func(err error) {
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	collector := collectorOf(ctx)
+
+	keys := make(chan listedKey)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for listed := range keys {
+				obj, err := get(ctx, listed.key)
+				if errors.Is(err, ErrObjectNotFound) {
+					obj, err = nil, nil
+				} else if err != nil && collector != nil && ctx.Err() == nil {
+					// objects which can't be fetched are passed on as
+					// skipped, so the numbering stays complete
+					collector.add(listed.key, err)
+					obj, err = nil, nil
+				}
+				if err == nil {
+					err = fn(listed.seq, listed.key, obj)
+				}
+				if err != nil && collector != nil && ctx.Err() == nil {
+					collector.add(listed.key, err)
+				} else if err != nil {
+					fail(fmt.Errorf("%s: %w", listed.key, err))
+				}
+			}
+		}()
+	}
+
+	it := cs.readAhead(ctx, cs.list(ctx, prefix, "Name"))
+	seq := 0
+list:
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			fail(err)
+			break
+		}
+		key, ok := cs.Key(attrs.Name)
+		if !ok {
+			continue
+		}
+		select {
+		case keys <- listedKey{seq: seq, key: key}:
+			seq++
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(keys)
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
+
+// ErrorCollector makes bulk operations such as ForEach process every object
+// even if some fail, e.g.
+//
+//	var errs ErrorCollector
+//	err := store.ForEach(CollectErrors(ctx, &errs), prefix, 8, fn)
+//	if err == nil {
+//		err = errs.Err()
+//	}
+type ErrorCollector struct {
+	mu     sync.Mutex
+	errors map[string]error
+}
+
+type collectorKey struct{}
+
+// CollectErrors returns ctx making the bulk operations run with it record the
+// errors of fetching, decoding or processing an object in c by key, skipping
+// the object instead of cancelling the operation. Failed listings are still
+// returned.
+func CollectErrors(ctx context.Context, c *ErrorCollector) context.Context {
+	return context.WithValue(ctx, collectorKey{}, c)
+}
+
+func collectorOf(ctx context.Context) *ErrorCollector {
+	c, _ := ctx.Value(collectorKey{}).(*ErrorCollector)
+	return c
+}
+
+// Collect returns fn recording its errors in c by key instead of returning
+// them. Errors fetching the objects still cancel the operation, see
+// CollectErrors to record them as well.
+func Collect[T any](c *ErrorCollector, fn func(key string, obj *T) error) func(string, *T) error {
+	return func(key string, obj *T) error {
+		if err := fn(key, obj); err != nil {
+			c.add(key, err)
+		}
+		return nil
+	}
+}
+
+func (c *ErrorCollector) add(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.errors == nil {
+		c.errors = make(map[string]error)
+	}
+	c.errors[key] = err
+}
+
+// Errors returns the collected errors by key.
+func (c *ErrorCollector) Errors() map[string]error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	errs := make(map[string]error, len(c.errors))
+	for key, err := range c.errors {
+		errs[key] = err
+	}
+	return errs
+}
+
+// Err summarizes the collected errors, nil if there are none. It wraps the
+// error of the first key in order.
+func (c *ErrorCollector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errors) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(c.errors))
+	for key := range c.errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Errorf("%d objects failed, first %s: %w", len(keys), keys[0], c.errors[keys[0]])
+}
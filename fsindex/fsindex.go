@@ -0,0 +1,245 @@
+// Package fsindex mirrors selected fields of the objects of a store into
+// Firestore, serving lookups that would otherwise need a scan of the bucket
+// while the payloads stay in Cloud Storage.
+package fsindex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/iterator"
+)
+
+// fieldKey is the document field holding the key of the object.
+const fieldKey = "_key"
+
+var _ objectstore.IndexedStore[struct{}] = (*Store[struct{}])(nil)
+
+// Store is a CRUDStore mirroring the fields of every object written through
+// it into a Firestore collection, one document per key.
+//
+// Index writes happen after the write to the bucket. If they fail, the key
+// is enqueued in the repair queue and the write still succeeds; Repair
+// brings the documents of queued keys up to date. Writes by concurrent
+// writers can be mirrored out of order, leaving an entry stale until the
+// next write of the key or a Rebuild.
+type Store[T any] struct {
+	objectstore.CRUDStore[T]
+	collection *firestore.CollectionRef
+	fields     func(obj *T) map[string]interface{}
+	repairs    *objectstore.Queue[string]
+}
+
+// New wraps store to mirror the fields returned by fields into collection.
+// Keys whose index write failed are enqueued in repairs, e.g.
+// objectstore.NewQueue[string](cs, "_fsindex/repairs/").
+func New[T any](
+	store objectstore.CRUDStore[T],
+	collection *firestore.CollectionRef,
+	fields func(obj *T) map[string]interface{},
+	repairs *objectstore.Queue[string],
+) *Store[T] {
+	return &Store[T]{CRUDStore: store, collection: collection, fields: fields, repairs: repairs}
+}
+
+// QueryIndexed returns the objects whose indexed field at path compares to
+// value with op, one of the Firestore operators such as "==", "<" or
+// "array-contains", or "=" as used by objectstore.Query. Documents whose
+// object has been deleted are skipped.
+//
+// Queries of the store look up their first filter here, so mirror the fields
+// they filter on under their JSON names.
+func (s *Store[T]) QueryIndexed(ctx context.Context, path, op string, value interface{}) ([]objectstore.Entry[T], error) {
+	if op == "=" {
+		op = "=="
+	}
+	it := s.collection.Where(path, op, value).Documents(ctx)
+	defer it.Stop()
+
+	var entries []objectstore.Entry[T]
+	for {
+		snap, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("QueryIndexed %s: %w", path, err)
+		}
+		key, ok := snap.Data()[fieldKey].(string)
+		if !ok {
+			continue
+		}
+		entry, err := s.CRUDStore.GetEntry(ctx, key)
+		if errors.Is(err, objectstore.ErrObjectNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("QueryIndexed %s: %w", path, err)
+		}
+		entries = append(entries, *entry)
+	}
+}
+
+// Repair updates the documents of the keys in the repair queue from the
+// objects in the bucket, until the queue is empty. Keys which fail again stay
+// queued. It returns the number of repaired keys.
+func (s *Store[T]) Repair(ctx context.Context) (int, error) {
+	repaired := 0
+	for {
+		lease, err := s.repairs.Lease(ctx, time.Minute)
+		if errors.Is(err, objectstore.ErrQueueEmpty) {
+			return repaired, nil
+		} else if err != nil {
+			return repaired, fmt.Errorf("Repair: %w", err)
+		}
+
+		if err := s.reindex(ctx, lease.Value); err != nil {
+			_ = s.repairs.Nack(ctx, lease)
+			return repaired, fmt.Errorf("Repair %s: %w", lease.Value, err)
+		}
+		if err := s.repairs.Ack(ctx, lease); err != nil {
+			return repaired, fmt.Errorf("Repair %s: %w", lease.Value, err)
+		}
+		repaired++
+	}
+}
+
+// Rebuild writes the documents of all objects under prefix, e.g. after
+// adding fields or to populate a new index.
+func (s *Store[T]) Rebuild(ctx context.Context, prefix string, workers int) error {
+	err := s.CRUDStore.ForEach(ctx, prefix, workers, func(key string, obj *T) error {
+		return s.set(ctx, key, obj)
+	})
+	if err != nil {
+		return fmt.Errorf("Rebuild %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (s *Store[T]) Create(ctx context.Context, key string, obj T) error {
+	if err := s.CRUDStore.Create(ctx, key, obj); err != nil {
+		return err
+	}
+	return s.mirror(ctx, key, &obj)
+}
+
+func (s *Store[T]) Put(ctx context.Context, key string, obj T) error {
+	if err := s.CRUDStore.Put(ctx, key, obj); err != nil {
+		return err
+	}
+	return s.mirror(ctx, key, &obj)
+}
+
+func (s *Store[T]) Set(ctx context.Context, key string, obj T) error {
+	if err := s.CRUDStore.Set(ctx, key, obj); err != nil {
+		return err
+	}
+	return s.mirror(ctx, key, &obj)
+}
+
+func (s *Store[T]) GetOrCreate(ctx context.Context, key string, create func() (T, error)) (*T, bool, error) {
+	obj, created, err := s.CRUDStore.GetOrCreate(ctx, key, create)
+	if err != nil || !created {
+		return obj, created, err
+	}
+	return obj, created, s.mirror(ctx, key, obj)
+}
+
+func (s *Store[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	obj, err := s.CRUDStore.Patch(ctx, key, patch)
+	if err != nil {
+		return nil, err
+	}
+	return obj, s.mirror(ctx, key, obj)
+}
+
+func (s *Store[T]) Swap(ctx context.Context, key string, obj T) (*T, error) {
+	previous, err := s.CRUDStore.Swap(ctx, key, obj)
+	if err != nil {
+		return nil, err
+	}
+	return previous, s.mirror(ctx, key, &obj)
+}
+
+func (s *Store[T]) Delete(ctx context.Context, key string) error {
+	if err := s.CRUDStore.Delete(ctx, key); err != nil {
+		return err
+	}
+	return s.mirror(ctx, key, nil)
+}
+
+func (s *Store[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) error {
+	if err := s.CRUDStore.DeleteIfGeneration(ctx, key, generation); err != nil {
+		return err
+	}
+	return s.mirror(ctx, key, nil)
+}
+
+// DeleteAll removes the document of every key as it is deleted.
+func (s *Store[T]) DeleteAll(ctx context.Context, prefix string, progress objectstore.ProgressFunc) error {
+	var mirrorErr error
+	err := s.CRUDStore.DeleteAll(ctx, prefix, func(done, total int, lastKey string) {
+		if err := s.mirror(ctx, lastKey, nil); err != nil && mirrorErr == nil {
+			mirrorErr = err
+		}
+		if progress != nil {
+			progress(done, total, lastKey)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return mirrorErr
+}
+
+// mirror writes the document of key, or deletes it if obj is nil, enqueuing
+// the key for Repair if that fails. An error is only returned if the key
+// couldn't be enqueued either.
+func (s *Store[T]) mirror(ctx context.Context, key string, obj *T) error {
+	var err error
+	if obj != nil {
+		err = s.set(ctx, key, obj)
+	} else {
+		_, err = s.doc(key).Delete(ctx)
+	}
+	if err == nil {
+		return nil
+	}
+	if _, qerr := s.repairs.Enqueue(ctx, key); qerr != nil {
+		return fmt.Errorf("index %s: %v, enqueue repair: %w", key, err, qerr)
+	}
+	return nil
+}
+
+// reindex brings the document of key up to date with the object in the
+// bucket.
+func (s *Store[T]) reindex(ctx context.Context, key string) error {
+	obj, err := s.CRUDStore.Get(ctx, key)
+	if errors.Is(err, objectstore.ErrObjectNotFound) {
+		_, err = s.doc(key).Delete(ctx)
+		return err
+	} else if err != nil {
+		return err
+	}
+	return s.set(ctx, key, obj)
+}
+
+func (s *Store[T]) set(ctx context.Context, key string, obj *T) error {
+	data := s.fields(obj)
+	if data == nil {
+		data = make(map[string]interface{}, 1)
+	}
+	data[fieldKey] = key
+	_, err := s.doc(key).Set(ctx, data)
+	return err
+}
+
+// doc returns the document of key. Keys are escaped since document IDs can't
+// contain slashes.
+func (s *Store[T]) doc(key string) *firestore.DocumentRef {
+	return s.collection.Doc(url.QueryEscape(key))
+}
@@ -0,0 +1,153 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// PrimaryFunc maps the key of a derived object (an index, thumbnail, cache
+// entry, ...) to the key of the primary object it was derived from.
+// ok is false for objects which are not derived and should be left alone.
+type PrimaryFunc func(key string) (primary string, ok bool)
+
+// maxCheckedPrimaries bounds the number of primaries whose existence is
+// remembered while collecting, so that scanning large prefixes doesn't keep
+// every primary in memory.
+const maxCheckedPrimaries = 10000
+
+// GarbageCollector deletes derived objects whose primary object is gone.
+type GarbageCollector struct {
+	cs        *CloudStorage
+	primary   PrimaryFunc
+	batchSize int
+}
+
+// NewGarbageCollector creates a GarbageCollector which deletes orphans in
+// batches of batchSize concurrent deletes. Defaults to 100 if batchSize <= 0.
+func NewGarbageCollector(cs *CloudStorage, primary PrimaryFunc, batchSize int) *GarbageCollector {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &GarbageCollector{cs: cs, primary: primary, batchSize: batchSize}
+}
+
+// Collect scans all keys under the key prefixes and deletes the derived
+// objects whose primary no longer exists. Objects which don't match the
+// filename format are skipped, and orphans changed since they were listed
+// are kept. It returns the number of deleted objects.
+func (gc *GarbageCollector) Collect(ctx context.Context, prefixes ...string) (int, error) {
+	exists := make(map[string]bool)
+	deleted := 0
+	for _, prefix := range prefixes {
+		n, err := gc.collect(ctx, prefix, exists)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// collect deletes the orphans under prefix, remembering in exists whether the
+// checked primaries exist.
+func (gc *GarbageCollector) collect(ctx context.Context, prefix string, exists map[string]bool) (deleted int, err error) {
+	defer gc.cs.finish("Collect", prefix, &err)
+
+	var batch []*storage.ObjectAttrs
+	it := gc.cs.list(ctx, prefix, "Name", "Generation")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return deleted, fmt.Errorf("Collect %s: %w", prefix, err)
+		}
+
+		key, ok := gc.cs.Key(attrs.Name)
+		if !ok {
+			continue
+		}
+		primary, ok := gc.primary(key)
+		if !ok {
+			continue
+		}
+		found, checked := exists[primary]
+		if !checked {
+			_, err := gc.cs.objectAttrs(ctx, gc.cs.Filename(primary))
+			if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				return deleted, fmt.Errorf("Collect %s: Attrs %s: %w", prefix, primary, withDetails("Collect", primary, err))
+			}
+			found = err == nil
+			if len(exists) >= maxCheckedPrimaries {
+				exists = make(map[string]bool)
+			}
+			exists[primary] = found
+		}
+		if found {
+			continue
+		}
+
+		batch = append(batch, attrs)
+		if len(batch) == gc.batchSize {
+			n, err := gc.deleteBatch(ctx, batch)
+			deleted += n
+			if err != nil {
+				return deleted, fmt.Errorf("Collect %s: %w", prefix, err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	n, err := gc.deleteBatch(ctx, batch)
+	deleted += n
+	if err != nil {
+		return deleted, fmt.Errorf("Collect %s: %w", prefix, err)
+	}
+	return deleted, nil
+}
+
+// deleteBatch concurrently deletes the listed generations of objects and
+// returns the number of deleted objects together with the first error
+// encountered. Objects which are gone or were rewritten since they were
+// listed are skipped.
+func (gc *GarbageCollector) deleteBatch(ctx context.Context, objects []*storage.ObjectAttrs) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		deleted  int
+		firstErr error
+	)
+	for _, attrs := range objects {
+		wg.Add(1)
+		go func(attrs *storage.ObjectAttrs) {
+			defer wg.Done()
+			err := gc.delete(ctx, attrs)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				deleted++
+			} else if !errors.Is(err, storage.ErrObjectNotExist) && !isPreconditionFailed(err) && firstErr == nil {
+				firstErr = fmt.Errorf("Delete %s: %w", attrs.Name, withDetails("Delete", attrs.Name, err))
+			}
+		}(attrs)
+	}
+	wg.Wait()
+	return deleted, firstErr
+}
+
+// delete deletes the listed generation of the object, holding an operation
+// slot while doing so.
+func (gc *GarbageCollector) delete(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	release, err := gc.cs.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return gc.cs.bucket.Object(attrs.Name).If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+}
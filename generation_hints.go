@@ -0,0 +1,73 @@
+package objectstore
+
+import (
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// generationHints remembers the generations of objects seen by a store for a
+// short time. A nil *generationHints is a no-op.
+type generationHints struct {
+	mu    sync.Mutex
+	hints map[string]generationHint
+	ttl   time.Duration
+}
+
+type generationHint struct {
+	generation int64
+	seen       time.Time
+}
+
+func newGenerationHints(ttl time.Duration) *generationHints {
+	return &generationHints{hints: make(map[string]generationHint), ttl: ttl}
+}
+
+// get returns the generation of key, or 0 if it isn't known.
+func (h *generationHints) get(key string) int64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hint, ok := h.hints[key]
+	if !ok || time.Since(hint.seen) > h.ttl {
+		delete(h.hints, key)
+		return 0
+	}
+	return hint.generation
+}
+
+func (h *generationHints) set(key string, generation int64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hints[key] = generationHint{generation: generation, seen: time.Now()}
+}
+
+func (h *generationHints) evict(key string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hints, key)
+}
+
+// track returns a writer option capturing the writer of key, and a func
+// remembering the generation it wrote, to be called once the write succeeded.
+func (h *generationHints) track(key string) (func(*storage.Writer), func()) {
+	var writer *storage.Writer
+	capture := func(w *storage.Writer) { writer = w }
+	if h == nil {
+		return capture, func() {}
+	}
+	return capture, func() {
+		if writer != nil && writer.Attrs() != nil {
+			h.set(key, writer.Attrs().Generation)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// GetOrCreate returns the object at key, or creates it with the value made by
+// factory if it doesn't exist. The returned bool reports whether the object
+// was created. Losing a race to create the object returns the winner's value.
+func (q *querier[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (_ *T, _ bool, err error) {
+	defer q.cs.finish("GetOrCreate", key, &err)
+	return getOrCreate(ctx, key, q.Get, q.Create, factory)
+}
+
+// GetOrCreate
+func (s *contentAddressedStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	return getOrCreate(ctx, key, s.Get, s.Create, factory)
+}
+
+func getOrCreate[T any](
+	ctx context.Context,
+	key string,
+	get func(context.Context, string) (*T, error),
+	create func(context.Context, string, T) error,
+	factory func() (T, error),
+) (*T, bool, error) {
+	obj, err := get(ctx, key)
+	if err == nil {
+		return obj, false, nil
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+
+	created, err := factory()
+	if err != nil {
+		return nil, false, fmt.Errorf("GetOrCreate %s: factory: %w", key, err)
+	}
+	err = create(ctx, key, created)
+	if err == nil {
+		return &created, true, nil
+	} else if !isPreconditionFailed(err) {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+
+	// someone else created it in the meantime
+	obj, err = get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+	return obj, false, nil
+}
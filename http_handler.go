@@ -0,0 +1,271 @@
+package objectstore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// HTTPHandlerOptions configures the handler created by NewHTTPHandler.
+type HTTPHandlerOptions struct {
+	// Key maps the object names of listings to keys, typically the Key
+	// method of the CloudStorage of the store.
+	// Defaults to using object names as keys
+	Key func(name string) (string, bool)
+
+	// ReadOnly rejects PUT, POST, PATCH and DELETE requests.
+	ReadOnly bool
+
+	// MaxBodySize limits the size of request bodies in bytes.
+	// Defaults to 10 MiB
+	MaxBodySize int64
+
+	// Cursors paginates listings. The listing starts at the cursor query
+	// parameter and the cursor of the next page is returned in the
+	// X-Next-Cursor header, absent on the last page.
+	// Defaults to unpaginated listings
+	Cursors *Cursors
+
+	// PageSize is the maximum number of objects per page of paginated
+	// listings.
+	// Defaults to 100
+	PageSize int
+}
+
+// NewHTTPHandler serves store as a REST resource. The request path, with any
+// mount prefix stripped by http.StripPrefix, is the key:
+//
+//	GET    /<key>     the object, with its generation as ETag
+//	PUT    /<key>     creates or replaces the object, If-Match is ignored
+//	POST   /<key>     creates the object, 409 if it exists
+//	PATCH  /<key>     applies a JSON merge patch
+//	DELETE /<key>     deletes the object, only at the If-Match generation if given
+//	GET    /<prefix>/ lists the objects under prefix, paginated with Cursors
+//
+// ErrObjectNotFound is served as 404, concurrent changes as 409 and
+// If-Match mismatches of DELETE as 412. Writes to a ReadOnly store are served
+// as 405, to immutable objects as 409 and forbidden public access as 403. A
+// GET with If-None-Match of the current generation is served as 304 without
+// downloading the object.
+func NewHTTPHandler[T any](store CRUDStore[T], opts HTTPHandlerOptions) http.Handler {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 10 << 20
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+	return &httpHandler[T]{store: store, opts: opts}
+}
+
+type httpHandler[T any] struct {
+	store CRUDStore[T]
+	opts  HTTPHandlerOptions
+}
+
+// listedObject is an object of a listing.
+type listedObject struct {
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	Updated    time.Time `json:"updated"`
+	Generation int64     `json:"generation"`
+}
+
+func (h *httpHandler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && h.opts.ReadOnly {
+		http.Error(w, "store is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "listings are read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		h.list(w, r, key)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, key)
+	case http.MethodPut, http.MethodPost:
+		var obj T
+		if !h.decodeBody(w, r, &obj) {
+			return
+		}
+		var err error
+		if r.Method == http.MethodPost {
+			err = h.store.Create(r.Context(), key, obj)
+		} else {
+			err = h.store.Put(r.Context(), key, obj)
+		}
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodPatch:
+		var patch json.RawMessage
+		if !h.decodeBody(w, r, &patch) {
+			return
+		}
+		obj, err := h.store.Patch(r.Context(), key, patch)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		writeHTTPJSON(w, obj)
+	case http.MethodDelete:
+		var err error
+		// If-Match: * only requires the object to exist, as Delete does
+		if match := r.Header.Get("If-Match"); match != "" && match != "*" {
+			generation, perr := parseETag(match)
+			if perr != nil {
+				http.Error(w, "invalid If-Match", http.StatusBadRequest)
+				return
+			}
+			err = h.store.DeleteIfGeneration(r.Context(), key, generation)
+			if errors.Is(err, ErrGenerationMismatch) {
+				http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+				return
+			}
+		} else {
+			err = h.store.Delete(r.Context(), key)
+		}
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, PATCH, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpHandler[T]) get(w http.ResponseWriter, r *http.Request, key string) {
+	var known int64
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		known, _ = parseETag(match)
+	}
+	obj, generation, err := h.store.GetIfChanged(r.Context(), key, known)
+	if errors.Is(err, ErrNotModified) {
+		w.Header().Set("ETag", formatETag(known))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	} else if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("ETag", formatETag(generation))
+	writeHTTPJSON(w, obj)
+}
+
+func (h *httpHandler[T]) list(w http.ResponseWriter, r *http.Request, prefix string) {
+	it := h.store.List(r.Context(), prefix)
+	var page []*storage.ObjectAttrs
+	if h.opts.Cursors != nil {
+		var next string
+		var err error
+		page, next, err = h.opts.Cursors.Page(it, prefix, r.URL.Query().Get("cursor"), h.opts.PageSize)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+	} else {
+		for {
+			attrs, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			} else if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			page = append(page, attrs)
+		}
+	}
+
+	objects := []listedObject{}
+	for _, attrs := range page {
+		key := attrs.Name
+		if h.opts.Key != nil {
+			var ok bool
+			if key, ok = h.opts.Key(attrs.Name); !ok {
+				continue
+			}
+		}
+		objects = append(objects, listedObject{
+			Key:        key,
+			Size:       attrs.Size,
+			Updated:    attrs.Updated,
+			Generation: attrs.Generation,
+		})
+	}
+	writeHTTPJSON(w, objects)
+}
+
+// decodeBody decodes the JSON request body into v, writing a 400 response
+// and returning false if it fails.
+func (h *httpHandler[T]) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	body := http.MaxBytesReader(w, r.Body, h.opts.MaxBodySize)
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// httpStatus maps the errors of the stores to HTTP status codes.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrGenerationMismatch), errors.Is(err, ErrObjectExists), errors.Is(err, ErrObjectHeld),
+		errors.Is(err, ErrImmutable), isPreconditionFailed(err):
+		return http.StatusConflict
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, ErrPublicAccessForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrObjectTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidGeneration):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := httpStatus(err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func writeHTTPJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func formatETag(generation int64) string {
+	return `"` + strconv.FormatInt(generation, 10) + `"`
+}
+
+func parseETag(etag string) (int64, error) {
+	etag = strings.TrimPrefix(etag, "W/")
+	return strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+}
@@ -0,0 +1,269 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// IndexEntry is an entry of the tag index, recording that the object at Key
+// is tagged with Tag.
+type IndexEntry struct {
+	Tag string
+	Key string
+}
+
+// IndexReport is the outcome of IndexChecker.Verify.
+type IndexReport struct {
+	// Dangling are index entries whose object is gone or no longer tagged.
+	Dangling []IndexEntry
+	// Missing are tags of objects without an index entry.
+	Missing []IndexEntry
+	// Repaired is the number of entries deleted or written by the repair.
+	Repaired int
+}
+
+// ContentReport is the outcome of IndexChecker.VerifyContent.
+type ContentReport struct {
+	// Dangling are the keys of aliases whose content is gone.
+	Dangling []string
+	// Unreferenced are the hashes of content no alias points to.
+	Unreferenced []string
+	// Repaired is the number of aliases and contents deleted by the repair.
+	Repaired int
+}
+
+// IndexChecker cross-checks the tag index written with WithTagIndex against
+// the tags of the objects, and the aliases of a content addressed store
+// against its content, like fsck. The tag index drifts when tagged objects
+// are deleted or a Tag or Untag fails halfway, while deleted keys leave their
+// content unreferenced.
+type IndexChecker struct {
+	cs     *CloudStorage
+	repair bool
+}
+
+// NewIndexChecker creates an IndexChecker, which also deletes dangling and
+// writes missing entries if repair is set.
+func NewIndexChecker(cs *CloudStorage, repair bool) *IndexChecker {
+	return &IndexChecker{cs: cs, repair: repair}
+}
+
+// Verify compares the tag index with the tags of the objects under prefixes,
+// or of the whole bucket if none are given. Every suspect entry is checked
+// again before it is reported, so tags changing during the scan aren't
+// reported as inconsistencies.
+func (c *IndexChecker) Verify(ctx context.Context, prefixes ...string) (*IndexReport, error) {
+	cs := c.cs
+	if cs.tagindex == "" {
+		return nil, errors.New("Verify: no tag index, see WithTagIndex")
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	indexed, err := c.indexed(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Verify: %w", err)
+	}
+	tagged := make(map[IndexEntry]bool)
+	for _, prefix := range prefixes {
+		it := cs.list(ctx, prefix, "Name", "Metadata")
+		for {
+			attrs, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("Verify %s: %w", prefix, err)
+			}
+			if strings.HasPrefix(attrs.Name, cs.tagindex) {
+				continue
+			}
+			key, ok := cs.Key(attrs.Name)
+			if !ok {
+				continue
+			}
+			for _, tag := range tagsOf(attrs.Metadata) {
+				tagged[IndexEntry{tag, key}] = true
+			}
+		}
+	}
+
+	report := &IndexReport{}
+	for entry := range indexed {
+		if tagged[entry] {
+			continue
+		}
+		ok, err := c.isTagged(ctx, entry)
+		if err != nil {
+			return report, fmt.Errorf("Verify: %w", err)
+		} else if ok {
+			continue
+		}
+		report.Dangling = append(report.Dangling, entry)
+		if c.repair {
+			if err := cs.deleteTagIndex(ctx, entry.Tag, entry.Key); err != nil {
+				return report, fmt.Errorf("Verify: repair %s %s: %w", entry.Tag, entry.Key, err)
+			}
+			report.Repaired++
+		}
+	}
+	for entry := range tagged {
+		if indexed[entry] {
+			continue
+		}
+		_, err := cs.bucket.Object(cs.tagIndexEntry(entry.Tag, entry.Key)).Attrs(ctx)
+		if err = wrapStorageError(err); err == nil {
+			continue
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return report, fmt.Errorf("Verify: %w", err)
+		}
+		report.Missing = append(report.Missing, entry)
+		if c.repair {
+			if err := cs.writeTagIndex(ctx, entry.Tag, entry.Key); err != nil {
+				return report, fmt.Errorf("Verify: repair %s %s: %w", entry.Tag, entry.Key, err)
+			}
+			report.Repaired++
+		}
+	}
+	return report, nil
+}
+
+// VerifyContent compares the aliases under prefixes, or of the whole bucket
+// if none are given, with the content of a store created with
+// NewContentAddressedStore(cs, contentPrefix). The prefixes must only hold
+// aliases of the store, apart from the content itself.
+//
+// Repairing deletes dangling aliases, whose payload is lost anyway, and
+// unreferenced content. Content written since the verification started isn't
+// reported, as its alias may not be written yet, and writes deduplicated
+// against content being deleted write it again once their alias is stored.
+func (c *IndexChecker) VerifyContent(ctx context.Context, contentPrefix string, prefixes ...string) (*ContentReport, error) {
+	cs := c.cs
+	started := time.Now()
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	contents := make(map[string]*storage.ObjectAttrs)
+	it := cs.listNames(ctx, contentPrefix, "Name", "Generation", "Created")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("VerifyContent: %w", err)
+		}
+		contents[strings.TrimPrefix(attrs.Name, contentPrefix)] = attrs
+	}
+
+	aliases := newQuerier[contentAlias](cs)
+	get := func(ctx context.Context, key string) (*contentAlias, error) {
+		if strings.HasPrefix(cs.Filename(key), contentPrefix) {
+			return nil, ErrObjectNotFound
+		}
+		return aliases.Get(ctx, key)
+	}
+	var mu sync.Mutex
+	referenced := make(map[string]bool)
+	var suspects []string
+	for _, prefix := range prefixes {
+		err := forEach(ctx, cs, prefix, reduceWorkers, get, func(key string, alias *contentAlias) error {
+			mu.Lock()
+			defer mu.Unlock()
+			referenced[alias.Hash] = true
+			if contents[alias.Hash] == nil {
+				suspects = append(suspects, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("VerifyContent %s: %w", prefix, err)
+		}
+	}
+
+	report := &ContentReport{}
+	for _, key := range suspects {
+		// the alias may have been written after the content was listed
+		alias, generation, err := aliases.GetIfChanged(ctx, key, 0)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		} else if err != nil {
+			return report, fmt.Errorf("VerifyContent: %w", err)
+		}
+		_, err = cs.bucket.Object(contentPrefix + alias.Hash).Attrs(ctx)
+		if err = wrapStorageError(err); err == nil {
+			continue
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return report, fmt.Errorf("VerifyContent: %w", err)
+		}
+		report.Dangling = append(report.Dangling, key)
+		if c.repair {
+			err := aliases.DeleteIfGeneration(ctx, key, generation)
+			if err == nil {
+				report.Repaired++
+			} else if !errors.Is(err, ErrGenerationMismatch) && !errors.Is(err, ErrObjectNotFound) {
+				return report, fmt.Errorf("VerifyContent: repair %s: %w", key, err)
+			}
+		}
+	}
+	for hash, attrs := range contents {
+		if referenced[hash] || !attrs.Created.Before(started) {
+			continue
+		}
+		report.Unreferenced = append(report.Unreferenced, hash)
+		if c.repair {
+			err := cs.bucket.Object(attrs.Name).If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+			if err == nil {
+				report.Repaired++
+			} else if !isPreconditionFailed(err) && !errors.Is(err, storage.ErrObjectNotExist) {
+				return report, fmt.Errorf("VerifyContent: repair %s: %w", hash, err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// indexed lists all entries of the tag index.
+func (c *IndexChecker) indexed(ctx context.Context) (map[IndexEntry]bool, error) {
+	cs := c.cs
+	entries := make(map[IndexEntry]bool)
+	it := cs.listNames(ctx, cs.tagindex, "Name")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		escaped, name, ok := strings.Cut(strings.TrimPrefix(attrs.Name, cs.tagindex), "/")
+		if !ok {
+			continue
+		}
+		tag, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		if key, ok := cs.Key(name); ok {
+			entries[IndexEntry{tag, key}] = true
+		}
+	}
+}
+
+// isTagged reports whether the object of entry currently has its tag.
+func (c *IndexChecker) isTagged(ctx context.Context, entry IndexEntry) (bool, error) {
+	attrs, err := c.cs.bucket.Object(c.cs.Filename(entry.Key)).Attrs(ctx)
+	if err = wrapStorageError(err); errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return attrs.Metadata[metaTagPrefix+entry.Tag] == "true", nil
+}
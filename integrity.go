@@ -0,0 +1,82 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrIntegrity is returned by reads of stores configured WithIntegrityCheck
+// if the payload doesn't match the hash recorded when it was written.
+var ErrIntegrity = errors.New("object failed integrity check")
+
+const metaSHA256 = "objectstore-sha256"
+
+// integrity records the hash of data in the metadata of the written object.
+func (q *querier[T]) integrity(data []byte) []func(*storage.Writer) {
+	if !q.cfg.integrityCheck {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return []func(*storage.Writer){func(w *storage.Writer) {
+		setMetadata(w, metaSHA256, hex.EncodeToString(sum[:]))
+	}}
+}
+
+// verify checks data against the hash recorded in metadata.
+func (q *querier[T]) verify(metadata map[string]string, data []byte) error {
+	if !q.cfg.integrityCheck {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return checkSHA256(metadata[metaSHA256], sum[:])
+}
+
+// verifying returns a reader of reader's payload which fails with
+// ErrIntegrity at the end of the payload if it doesn't match the recorded
+// hash. The metadata is read for the generation being downloaded, since the
+// reader attributes don't include it.
+func (q *querier[T]) verifying(ctx context.Context, key string, reader *storage.Reader) (io.Reader, error) {
+	if !q.cfg.integrityCheck {
+		return reader, nil
+	}
+	o := q.cs.readObject(q.cs.Filename(key)).Generation(reader.Attrs.Generation)
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, wrapStorageError(withDetails("Get", key, err))
+	}
+	return &verifyingReader{r: reader, hash: sha256.New(), expected: attrs.Metadata[metaSHA256]}, nil
+}
+
+type verifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.r.Read(p)
+	vr.hash.Write(p[:n])
+	if err == io.EOF {
+		if verr := checkSHA256(vr.expected, vr.hash.Sum(nil)); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func checkSHA256(expected string, sum []byte) error {
+	if expected == "" {
+		return fmt.Errorf("%w: no hash recorded", ErrIntegrity)
+	}
+	if actual := hex.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("%w: sha256 is %s, recorded %s", ErrIntegrity, actual, expected)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package objectstore
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// KeyEncoding encodes keys reversibly into object names, so arbitrary byte
+// strings and user provided keys such as emails or free text are valid and
+// unambiguous object names.
+type KeyEncoding int
+
+const (
+	// KeyEncodingNone uses keys as is.
+	KeyEncodingNone KeyEncoding = iota
+	// KeyEncodingOrderedBase64 encodes keys as unpadded base64 with a custom
+	// URL-safe alphabet in ASCII order, `0-9A-Z_a-z~`, so encoded names sort
+	// like the keys. It isn't decodable as standard base64 or base64url.
+	KeyEncodingOrderedBase64
+	// KeyEncodingHex encodes keys as lowercase hex.
+	KeyEncodingHex
+)
+
+// orderedBase64 is base64 whose alphabet is in ASCII order, so encodings
+// compare like the payloads. Every character sorts after `.` and `/`, so names
+// keep the order with a filename suffix such as `.json`.
+var orderedBase64 = base64.NewEncoding("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz~").WithPadding(base64.NoPadding)
+
+func (e KeyEncoding) encode(key string) string {
+	switch e {
+	case KeyEncodingOrderedBase64:
+		return orderedBase64.EncodeToString([]byte(key))
+	case KeyEncodingHex:
+		return hex.EncodeToString([]byte(key))
+	}
+	return key
+}
+
+func (e KeyEncoding) decode(encoded string) (string, bool) {
+	var key []byte
+	var err error
+	switch e {
+	case KeyEncodingOrderedBase64:
+		key, err = orderedBase64.DecodeString(encoded)
+	case KeyEncodingHex:
+		key, err = hex.DecodeString(encoded)
+	default:
+		return encoded, true
+	}
+	return string(key), err == nil
+}
+
+// encodePrefix encodes a key prefix so that the encodings of all keys
+// starting with it start with the result. Base64 only keeps prefixes of whole
+// 3 byte groups, so the result may also match other keys.
+func (e KeyEncoding) encodePrefix(prefix string) string {
+	if e == KeyEncodingOrderedBase64 {
+		prefix = prefix[:len(prefix)-len(prefix)%3]
+	}
+	return e.encode(prefix)
+}
+
+// successor returns the least key greater than every key starting with
+// prefix, false if there is none.
+func successor(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
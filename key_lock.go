@@ -0,0 +1,46 @@
+package objectstore
+
+import "sync"
+
+// keyLocks serializes work per key. A nil *keyLocks doesn't lock.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: make(map[string]*keyLock)}
+}
+
+// lock blocks until key is free and returns the func unlocking it.
+func (k *keyLocks) lock(key string) (unlock func()) {
+	if k == nil {
+		return func() {}
+	}
+
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		// drop the lock once nobody waits for it so the map doesn't grow forever
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
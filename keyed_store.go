@@ -0,0 +1,88 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// keyTag is the struct tag marking the fields a KeyedStore derives keys from.
+const keyTag = "objectstorage"
+
+// KeyedStore is a CRUDStore which derives the key of an object from the
+// fields of T tagged `objectstorage:"key"`, so the key can't drift from the
+// object. Several key fields are joined with `/` in declaration order.
+type KeyedStore[T any] struct {
+	CRUDStore[T]
+	fields []int
+}
+
+// NewKeyedStore wraps store, failing if T is not a struct with at least one
+// string or integer field tagged `objectstorage:"key"`.
+func NewKeyedStore[T any](store CRUDStore[T]) (*KeyedStore[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("KeyedStore: %s is not a struct", t)
+	}
+
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get(keyTag) != "key" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("KeyedStore: key field %s.%s has unsupported type %s", t, field.Name, field.Type)
+		}
+		fields = append(fields, i)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("KeyedStore: %s has no field tagged %s:\"key\"", t, keyTag)
+	}
+	return &KeyedStore[T]{CRUDStore: store, fields: fields}, nil
+}
+
+// Key derives the key of obj. Empty string key fields are an error.
+func (s *KeyedStore[T]) Key(obj T) (string, error) {
+	v := reflect.ValueOf(obj)
+	parts := make([]string, len(s.fields))
+	for i, index := range s.fields {
+		field := v.Field(index)
+		switch field.Kind() {
+		case reflect.String:
+			parts[i] = field.String()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			parts[i] = strconv.FormatUint(field.Uint(), 10)
+		default:
+			parts[i] = strconv.FormatInt(field.Int(), 10)
+		}
+		if parts[i] == "" {
+			return "", fmt.Errorf("key field %s is empty", v.Type().Field(index).Name)
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Save puts obj at its derived key.
+func (s *KeyedStore[T]) Save(ctx context.Context, obj T) error {
+	key, err := s.Key(obj)
+	if err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	return s.Put(ctx, key, obj)
+}
+
+// Remove deletes the object at the key derived from obj.
+func (s *KeyedStore[T]) Remove(ctx context.Context, obj T) error {
+	key, err := s.Key(obj)
+	if err != nil {
+		return fmt.Errorf("Remove: %w", err)
+	}
+	return s.Delete(ctx, key)
+}
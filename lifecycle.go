@@ -0,0 +1,106 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// LifecycleRule is a desired lifecycle rule of the bucket, acting on objects
+// under a key prefix once they reach an age.
+type LifecycleRule struct {
+	// Prefix is the key prefix the rule applies to, empty for all objects.
+	Prefix string
+	// Age is rounded up to whole days, the granularity of lifecycle rules.
+	Age time.Duration
+	// StorageClass moves objects to the storage class, e.g. "NEARLINE" or
+	// "COLDLINE". Objects are deleted if empty.
+	StorageClass string
+}
+
+// DeleteAfter is a LifecycleRule deleting objects under prefix at age.
+func DeleteAfter(prefix string, age time.Duration) LifecycleRule {
+	return LifecycleRule{Prefix: prefix, Age: age}
+}
+
+// TransitionAfter is a LifecycleRule moving objects under prefix to
+// storageClass at age.
+func TransitionAfter(prefix string, age time.Duration, storageClass string) LifecycleRule {
+	return LifecycleRule{Prefix: prefix, Age: age, StorageClass: storageClass}
+}
+
+// Lifecycle returns the lifecycle rules of the bucket.
+func (cs *CloudStorage) Lifecycle(ctx context.Context) ([]storage.LifecycleRule, error) {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Lifecycle: %w", err)
+	}
+	return attrs.Lifecycle.Rules, nil
+}
+
+// ReconcileLifecycle makes rules the lifecycle rules of the bucket, e.g. at
+// startup of the service owning the data. Rules not declared are removed, and
+// the bucket is left as is if it already matches.
+func (cs *CloudStorage) ReconcileLifecycle(ctx context.Context, rules ...LifecycleRule) error {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("ReconcileLifecycle: %w", err)
+	}
+
+	desired := make([]storage.LifecycleRule, len(rules))
+	for i, rule := range rules {
+		desired[i] = cs.lifecycleRule(rule)
+	}
+	if sameLifecycle(attrs.Lifecycle.Rules, desired) {
+		return nil
+	}
+
+	// only replace the rules we just read so concurrent updates aren't lost
+	_, err = cs.bucket.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).
+		Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &storage.Lifecycle{Rules: desired}})
+	if err != nil {
+		return fmt.Errorf("ReconcileLifecycle: %w", err)
+	}
+	return nil
+}
+
+func (cs *CloudStorage) lifecycleRule(rule LifecycleRule) storage.LifecycleRule {
+	day := 24 * time.Hour
+	lr := storage.LifecycleRule{
+		Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{
+			AgeInDays: int64((rule.Age + day - 1) / day),
+		},
+	}
+	if rule.Prefix != "" {
+		lr.Condition.MatchesPrefix = []string{cs.FilenamePrefix(rule.Prefix)}
+	}
+	if rule.StorageClass != "" {
+		lr.Action = storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: rule.StorageClass}
+	}
+	return lr
+}
+
+// sameLifecycle compares rules regardless of order.
+func sameLifecycle(a, b []storage.LifecycleRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	matched := make([]bool, len(b))
+	for _, ra := range a {
+		found := false
+		for i, rb := range b {
+			if !matched[i] && reflect.DeepEqual(ra, rb) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
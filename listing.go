@@ -0,0 +1,59 @@
+package objectstore
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+// objectIterator is the part of storage.ObjectIterator bulk operations use.
+type objectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+type listResult struct {
+	attrs *storage.ObjectAttrs
+	err   error
+}
+
+// readAhead consumes it in the background, buffering up to WithListReadAhead
+// objects. The buffer is abandoned once ctx is done, so callers stopping
+// before the end of the listing must cancel ctx.
+func (cs *CloudStorage) readAhead(ctx context.Context, it objectIterator) objectIterator {
+	if cs.listreadahead <= 0 {
+		return it
+	}
+	buf := make(chan listResult, cs.listreadahead)
+	go func() {
+		defer close(buf)
+		for {
+			attrs, err := it.Next()
+			select {
+			case buf <- listResult{attrs, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return bufferedIterator{ctx, buf}
+}
+
+type bufferedIterator struct {
+	ctx context.Context
+	buf <-chan listResult
+}
+
+func (it bufferedIterator) Next() (*storage.ObjectAttrs, error) {
+	select {
+	case l, ok := <-it.buf:
+		if !ok {
+			return nil, it.ctx.Err()
+		}
+		return l.attrs, l.err
+	case <-it.ctx.Done():
+		return nil, it.ctx.Err()
+	}
+}
@@ -0,0 +1,115 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+)
+
+// Patch applies the RFC 7386 JSON merge patch to the object at key and
+// returns the result. The patched object is only written back if the object
+// hasn't changed since it was read, otherwise the patch is re-applied to the
+// new version.
+func (q *querier[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (_ *T, err error) {
+	defer q.cs.finish("Patch", key, &err)
+	defer q.locks.lock(key)()
+
+	var obj *T
+	err = q.cfg.retries().run(ctx, func(ctx context.Context) (err error) {
+		obj, err = q.patch(ctx, key, patch)
+		return err
+	}, isPreconditionFailed)
+	if err != nil {
+		return nil, fmt.Errorf("Patch %s: %w", key, err)
+	}
+	q.cache.evict(key)
+	return obj, nil
+}
+
+func (q *querier[T]) patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	reader, err := q.open(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := q.document(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, encoded, err := applyPatch[T](q.codec, document, patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.cfg.checkSize(int64(len(encoded))); err != nil {
+		return nil, err
+	}
+	body, settle, err := q.cfg.quotas.reserveWrite(key, bytes.NewReader(encoded), &storage.ObjectAttrs{Size: reader.Attrs.Size})
+	if err != nil {
+		return nil, err
+	}
+	conds := storage.Conditions{GenerationMatch: reader.Attrs.Generation}
+	track, written := q.hints.track(key)
+	err = q.cs.writeFileIf(ctx, key, body, q.codec.ContentType(), conds, append(q.writeAttrs(encoded), track)...)
+	if settle(err); err != nil {
+		q.hints.evict(key)
+		return nil, err
+	}
+	written()
+	return obj, nil
+}
+
+// applyPatch merges patch into the JSON document and decodes the result as T,
+// returning it together with its encoding by codec.
+func applyPatch[T any](codec Codec, document []byte, patch json.RawMessage) (*T, []byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, nil, fmt.Errorf("patch: %w", err)
+	}
+	merged, err := json.Marshal(mergePatch(target, p))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// round trip through T so the stored document keeps the shape of T
+	var obj T
+	if err := json.Unmarshal(merged, &obj); err != nil {
+		return nil, nil, err
+	}
+	encoded, err := codec.Marshal(&obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &obj, encoded, nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7386.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
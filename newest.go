@@ -0,0 +1,64 @@
+package objectstore
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ListNewest returns the keys of the n most recently updated objects under
+// prefix, newest first. Objects which don't match the filename format are
+// skipped. The whole prefix is listed but only n objects are kept in memory.
+func (cs *CloudStorage) ListNewest(ctx context.Context, prefix string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	oldest := &attrsHeap{}
+	it := cs.list(ctx, prefix, "Name", "Updated")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ListNewest %s: %w", prefix, err)
+		}
+		if _, ok := cs.Key(attrs.Name); !ok {
+			continue
+		}
+
+		if oldest.Len() < n {
+			heap.Push(oldest, attrs)
+		} else if attrs.Updated.After((*oldest)[0].Updated) {
+			(*oldest)[0] = attrs
+			heap.Fix(oldest, 0)
+		}
+	}
+
+	newest := []*storage.ObjectAttrs(*oldest)
+	sort.Slice(newest, func(i, j int) bool { return newest[i].Updated.After(newest[j].Updated) })
+	keys := make([]string, len(newest))
+	for i, attrs := range newest {
+		keys[i], _ = cs.Key(attrs.Name)
+	}
+	return keys, nil
+}
+
+// attrsHeap is a min-heap of object attributes ordered by Updated.
+type attrsHeap []*storage.ObjectAttrs
+
+func (h attrsHeap) Len() int            { return len(h) }
+func (h attrsHeap) Less(i, j int) bool  { return h[i].Updated.Before(h[j].Updated) }
+func (h attrsHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *attrsHeap) Push(x interface{}) { *h = append(*h, x.(*storage.ObjectAttrs)) }
+func (h *attrsHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
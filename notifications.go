@@ -0,0 +1,77 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// EnsureNotifications makes the bucket publish the eventTypes of objects
+// under prefix to the Pub/Sub topic, given as `projects/<project>/topics/<topic>`,
+// e.g. for ChangeBridge. Empty eventTypes publish all events. An existing
+// notification of the topic and prefix is kept if it matches, and replaced
+// otherwise, since notifications can't be updated. The new notification is
+// created before the old one is deleted, so events may be published twice
+// while it is replaced but none are lost. Duplicate notifications of the
+// topic and prefix are deleted.
+func (cs *CloudStorage) EnsureNotifications(ctx context.Context, topic string, eventTypes []string, prefix string) (*storage.Notification, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return nil, fmt.Errorf("EnsureNotifications: topic %q isn't projects/<project>/topics/<topic>", topic)
+	}
+	want := &storage.Notification{
+		TopicProjectID:   parts[1],
+		TopicID:          parts[3],
+		EventTypes:       eventTypes,
+		ObjectNamePrefix: prefix,
+		PayloadFormat:    storage.JSONPayload,
+	}
+
+	existing, err := cs.bucket.Notifications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("EnsureNotifications: %w", err)
+	}
+	var current *storage.Notification
+	var stale []string
+	for id, n := range existing {
+		if n.TopicProjectID != want.TopicProjectID || n.TopicID != want.TopicID || n.ObjectNamePrefix != prefix {
+			continue
+		}
+		if current == nil && n.PayloadFormat == want.PayloadFormat && sameEventTypes(n.EventTypes, eventTypes) {
+			current = n
+		} else {
+			stale = append(stale, id)
+		}
+	}
+
+	if current == nil {
+		if current, err = cs.bucket.AddNotification(ctx, want); err != nil {
+			return nil, fmt.Errorf("EnsureNotifications: %w", err)
+		}
+	}
+	for _, id := range stale {
+		if err := cs.bucket.DeleteNotification(ctx, id); err != nil {
+			return current, fmt.Errorf("EnsureNotifications: delete %s: %w", id, err)
+		}
+	}
+	return current, nil
+}
+
+func sameEventTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
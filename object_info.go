@@ -0,0 +1,80 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ObjectInfo is the metadata of an object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	Updated     time.Time
+	Generation  int64
+	ContentType string
+	// Metadata is the custom metadata, only set by FindByMetadata.
+	Metadata map[string]string
+}
+
+// ListAttrs returns the metadata of all objects under prefix without
+// downloading them, e.g. for admin screens and reconciliation jobs. Objects
+// not matching the filename format are skipped.
+func (cs *CloudStorage) ListAttrs(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	it := cs.list(ctx, prefix, "Name", "Size", "Updated", "Generation", "ContentType")
+
+	var infos []ObjectInfo
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ListAttrs %s: %w", prefix, err)
+		}
+		if key, ok := cs.Key(attrs.Name); ok {
+			infos = append(infos, newObjectInfo(key, attrs))
+		}
+	}
+	return infos, nil
+}
+
+// FindByMetadata returns the objects under prefix whose custom metadata has
+// key set to value, e.g. all objects with pipeline=v2, filtering on the
+// listing instead of downloading the objects. The filtering happens client
+// side, so the whole prefix is still listed.
+func (cs *CloudStorage) FindByMetadata(ctx context.Context, prefix, key, value string) ([]ObjectInfo, error) {
+	it := cs.list(ctx, prefix, "Name", "Size", "Updated", "Generation", "ContentType", "Metadata")
+
+	var infos []ObjectInfo
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("FindByMetadata %s: %w", prefix, err)
+		}
+		if v, ok := attrs.Metadata[key]; !ok || v != value {
+			continue
+		}
+		if k, ok := cs.Key(attrs.Name); ok {
+			info := newObjectInfo(k, attrs)
+			info.Metadata = attrs.Metadata
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func newObjectInfo(key string, attrs *storage.ObjectAttrs) ObjectInfo {
+	return ObjectInfo{
+		Key:         key,
+		Size:        attrs.Size,
+		Updated:     attrs.Updated,
+		Generation:  attrs.Generation,
+		ContentType: attrs.ContentType,
+	}
+}
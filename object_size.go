@@ -0,0 +1,61 @@
+package objectstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrObjectTooLarge is returned by writes of payloads larger than configured
+// WithMaxObjectSize.
+var ErrObjectTooLarge = errors.New("object too large")
+
+// checkSize fails with ErrObjectTooLarge if size exceeds the maximum object
+// size.
+func (cfg storeConfig) checkSize(size int64) error {
+	if cfg.maxObjectSize > 0 && size > cfg.maxObjectSize {
+		return fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrObjectTooLarge, size, cfg.maxObjectSize)
+	}
+	return nil
+}
+
+// marshal encodes obj, failing with ErrObjectTooLarge before anything is
+// uploaded if the encoding exceeds the maximum object size.
+func (q *querier[T]) marshal(obj *T) ([]byte, error) {
+	data, err := q.codec.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.cfg.checkSize(int64(len(data))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// limitSize fails reads from r with ErrObjectTooLarge once more than the
+// maximum object size was read, which aborts the upload reading from it.
+// Readers of known size are checked up front.
+func (cfg storeConfig) limitSize(r io.Reader) (io.Reader, error) {
+	if cfg.maxObjectSize <= 0 {
+		return r, nil
+	}
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return r, cfg.checkSize(s.Size())
+	}
+	return &sizeLimitedReader{r: r, cfg: cfg}, nil
+}
+
+type sizeLimitedReader struct {
+	r   io.Reader
+	n   int64
+	cfg storeConfig
+}
+
+func (lr *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.n += int64(n)
+	if cerr := lr.cfg.checkSize(lr.n); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
@@ -0,0 +1,369 @@
+package objectstoretest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/googleapi"
+)
+
+// Faults configures the failures injected by NewFaultyStore. The rates are
+// probabilities in [0, 1] that a call fails in the given way, drawn once per
+// call, so their sum should not exceed 1.
+type Faults struct {
+	// ErrorRate is the probability that a call fails with Err.
+	ErrorRate float64
+
+	// Err is returned by calls failing due to ErrorRate. Defaults to a 503
+	// googleapi.Error, which the storage client and callers treat as
+	// transient.
+	Err error
+
+	// NotFoundRate, PreconditionRate and UnavailableRate are the
+	// probabilities that a call fails as if Cloud Storage responded with 404,
+	// 412 or 503. The errors match both the googleapi.Error of the response
+	// and the error the stores return for it: ErrObjectNotFound, and
+	// ErrObjectExists for creates or ErrGenerationMismatch for other writes.
+	NotFoundRate     float64
+	PreconditionRate float64
+	UnavailableRate  float64
+
+	// ReadFailureRate is the probability that a read fails with
+	// io.ErrUnexpectedEOF after the object was fetched, as if the connection
+	// dropped mid-stream. ForEach draws once per object, so it fails part way
+	// through the listing.
+	ReadFailureRate float64
+
+	// Latency is added before every call.
+	Latency time.Duration
+
+	// Ops restricts the faults to the named methods, e.g. "Get" or "Put".
+	// Faults apply to all methods if empty.
+	Ops []string
+
+	// Seed seeds the random source deciding which calls fail, making test
+	// runs reproducible.
+	Seed int64
+}
+
+// fault is an injected failure of a request to Cloud Storage.
+type fault struct {
+	op, key string
+	gerr    *googleapi.Error
+	// mask is the error the stores return for the response, if any.
+	mask error
+}
+
+func (f *fault) Error() string {
+	return fmt.Sprintf("%s %s: injected fault: %v", f.op, f.key, f.gerr)
+}
+
+func (f *fault) Unwrap() error {
+	return f.gerr
+}
+
+func (f *fault) Is(target error) bool {
+	return f.mask != nil && target == f.mask
+}
+
+// faultyStore decorates a CRUDStore with injected errors and latency.
+type faultyStore[T any] struct {
+	objectstore.CRUDStore[T]
+
+	faults Faults
+	ops    map[string]bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaultyStore wraps store, injecting the configured faults into its calls.
+// Listings returning a storage.ObjectIterator can't return arbitrary errors,
+// so failing List calls return an iterator whose Next fails with
+// context.Canceled.
+func NewFaultyStore[T any](store objectstore.CRUDStore[T], faults Faults) objectstore.CRUDStore[T] {
+	if faults.Err == nil {
+		faults.Err = &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "injected fault"}
+	}
+	f := &faultyStore[T]{
+		CRUDStore: store,
+		faults:    faults,
+		rand:      rand.New(rand.NewSource(faults.Seed)),
+	}
+	if len(faults.Ops) > 0 {
+		f.ops = make(map[string]bool)
+		for _, op := range faults.Ops {
+			f.ops[op] = true
+		}
+	}
+	return f
+}
+
+func (f *faultyStore[T]) draw() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64()
+}
+
+// inject sleeps for the configured latency and reports the error op should
+// fail with before reaching the store.
+func (f *faultyStore[T]) inject(ctx context.Context, op, key string) error {
+	if f.ops != nil && !f.ops[op] {
+		return nil
+	}
+	if f.faults.Latency > 0 {
+		select {
+		case <-time.After(f.faults.Latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p := f.draw()
+	if p -= f.faults.ErrorRate; p < 0 {
+		return f.faults.Err
+	}
+	if p -= f.faults.NotFoundRate; p < 0 {
+		return &fault{op: op, key: key, gerr: &googleapi.Error{Code: http.StatusNotFound, Message: "No such object"}, mask: objectstore.ErrObjectNotFound}
+	}
+	if p -= f.faults.PreconditionRate; p < 0 {
+		mask := objectstore.ErrGenerationMismatch
+		if op == "Create" || op == "GetOrCreate" {
+			mask = objectstore.ErrObjectExists
+		}
+		return &fault{op: op, key: key, gerr: &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "Precondition Failed"}, mask: mask}
+	}
+	if p -= f.faults.UnavailableRate; p < 0 {
+		return &fault{op: op, key: key, gerr: &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "Service Unavailable"}}
+	}
+	return nil
+}
+
+// interrupt reports the error a read of key by op should fail with after the
+// object was fetched.
+func (f *faultyStore[T]) interrupt(op, key string) error {
+	if f.ops != nil && !f.ops[op] || f.faults.ReadFailureRate <= 0 {
+		return nil
+	}
+	if f.draw() < f.faults.ReadFailureRate {
+		return fmt.Errorf("%s %s: %w", op, key, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (f *faultyStore[T]) Create(ctx context.Context, key string, obj T) error {
+	if err := f.inject(ctx, "Create", key); err != nil {
+		return err
+	}
+	return f.CRUDStore.Create(ctx, key, obj)
+}
+
+func (f *faultyStore[T]) Get(ctx context.Context, key string) (*T, error) {
+	if err := f.inject(ctx, "Get", key); err != nil {
+		return nil, err
+	}
+	obj, err := f.CRUDStore.Get(ctx, key)
+	if err == nil {
+		err = f.interrupt("Get", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (f *faultyStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	if err := f.inject(ctx, "GetOrCreate", key); err != nil {
+		return nil, false, err
+	}
+	obj, created, err := f.CRUDStore.GetOrCreate(ctx, key, factory)
+	if err == nil && !created {
+		err = f.interrupt("GetOrCreate", key)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return obj, created, nil
+}
+
+func (f *faultyStore[T]) GetEntry(ctx context.Context, key string) (*objectstore.Entry[T], error) {
+	if err := f.inject(ctx, "GetEntry", key); err != nil {
+		return nil, err
+	}
+	entry, err := f.CRUDStore.GetEntry(ctx, key)
+	if err == nil {
+		err = f.interrupt("GetEntry", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (f *faultyStore[T]) GetIfChanged(ctx context.Context, key string, generation int64) (*T, int64, error) {
+	if err := f.inject(ctx, "GetIfChanged", key); err != nil {
+		return nil, generation, err
+	}
+	obj, current, err := f.CRUDStore.GetIfChanged(ctx, key, generation)
+	if err == nil {
+		err = f.interrupt("GetIfChanged", key)
+	}
+	if err != nil {
+		return nil, generation, err
+	}
+	return obj, current, nil
+}
+
+func (f *faultyStore[T]) GetAsOf(ctx context.Context, key string, t time.Time) (*T, error) {
+	if err := f.inject(ctx, "GetAsOf", key); err != nil {
+		return nil, err
+	}
+	obj, err := f.CRUDStore.GetAsOf(ctx, key, t)
+	if err == nil {
+		err = f.interrupt("GetAsOf", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (f *faultyStore[T]) GetField(ctx context.Context, key, pointer string) (json.RawMessage, error) {
+	if err := f.inject(ctx, "GetField", key); err != nil {
+		return nil, err
+	}
+	field, err := f.CRUDStore.GetField(ctx, key, pointer)
+	if err == nil {
+		err = f.interrupt("GetField", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func (f *faultyStore[T]) Put(ctx context.Context, key string, obj T) error {
+	if err := f.inject(ctx, "Put", key); err != nil {
+		return err
+	}
+	return f.CRUDStore.Put(ctx, key, obj)
+}
+
+func (f *faultyStore[T]) Set(ctx context.Context, key string, obj T) error {
+	if err := f.inject(ctx, "Set", key); err != nil {
+		return err
+	}
+	return f.CRUDStore.Set(ctx, key, obj)
+}
+
+func (f *faultyStore[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	if err := f.inject(ctx, "Patch", key); err != nil {
+		return nil, err
+	}
+	return f.CRUDStore.Patch(ctx, key, patch)
+}
+
+func (f *faultyStore[T]) Swap(ctx context.Context, key string, obj T) (*T, error) {
+	if err := f.inject(ctx, "Swap", key); err != nil {
+		return nil, err
+	}
+	return f.CRUDStore.Swap(ctx, key, obj)
+}
+
+func (f *faultyStore[T]) Delete(ctx context.Context, key string) error {
+	if err := f.inject(ctx, "Delete", key); err != nil {
+		return err
+	}
+	return f.CRUDStore.Delete(ctx, key)
+}
+
+func (f *faultyStore[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) error {
+	if err := f.inject(ctx, "DeleteIfGeneration", key); err != nil {
+		return err
+	}
+	return f.CRUDStore.DeleteIfGeneration(ctx, key, generation)
+}
+
+// list returns the listing of op, or one failing immediately if a fault is
+// injected.
+func (f *faultyStore[T]) list(ctx context.Context, op, prefix string, list func(context.Context) *storage.ObjectIterator) *storage.ObjectIterator {
+	if err := f.inject(ctx, op, prefix); err != nil {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		return list(canceled)
+	}
+	return list(ctx)
+}
+
+func (f *faultyStore[T]) List(ctx context.Context, prefix string) *storage.ObjectIterator {
+	return f.list(ctx, "List", prefix, func(ctx context.Context) *storage.ObjectIterator {
+		return f.CRUDStore.List(ctx, prefix)
+	})
+}
+
+func (f *faultyStore[T]) ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator {
+	return f.list(ctx, "ListDelimited", prefix, func(ctx context.Context) *storage.ObjectIterator {
+		return f.CRUDStore.ListDelimited(ctx, prefix, delimiter)
+	})
+}
+
+func (f *faultyStore[T]) ListGlob(ctx context.Context, glob string) *storage.ObjectIterator {
+	return f.list(ctx, "ListGlob", glob, func(ctx context.Context) *storage.ObjectIterator {
+		return f.CRUDStore.ListGlob(ctx, glob)
+	})
+}
+
+func (f *faultyStore[T]) ListRange(ctx context.Context, start, end string) *storage.ObjectIterator {
+	return f.list(ctx, "ListRange", start, func(ctx context.Context) *storage.ObjectIterator {
+		return f.CRUDStore.ListRange(ctx, start, end)
+	})
+}
+
+func (f *faultyStore[T]) GetAll(ctx context.Context, prefix string, progress objectstore.ProgressFunc) ([]objectstore.Entry[T], error) {
+	if err := f.inject(ctx, "GetAll", prefix); err != nil {
+		return nil, err
+	}
+	entries, err := f.CRUDStore.GetAll(ctx, prefix, progress)
+	if err == nil {
+		err = f.interrupt("GetAll", prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f *faultyStore[T]) ForEach(ctx context.Context, prefix string, workers int, fn func(key string, obj *T) error) error {
+	if err := f.inject(ctx, "ForEach", prefix); err != nil {
+		return err
+	}
+	return f.CRUDStore.ForEach(ctx, prefix, workers, func(key string, obj *T) error {
+		// ForEach already names the key in its error
+		if f.interrupt("ForEach", key) != nil {
+			return io.ErrUnexpectedEOF
+		}
+		return fn(key, obj)
+	})
+}
+
+func (f *faultyStore[T]) DeleteAll(ctx context.Context, prefix string, progress objectstore.ProgressFunc) error {
+	if err := f.inject(ctx, "DeleteAll", prefix); err != nil {
+		return err
+	}
+	return f.CRUDStore.DeleteAll(ctx, prefix, progress)
+}
+
+func (f *faultyStore[T]) Prefetch(ctx context.Context, prefix string) error {
+	if err := f.inject(ctx, "Prefetch", prefix); err != nil {
+		return err
+	}
+	return f.CRUDStore.Prefetch(ctx, prefix)
+}
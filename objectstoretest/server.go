@@ -0,0 +1,865 @@
+// Package objectstoretest provides an in-memory fake of Google Cloud Storage
+// for testing code built on objectstore without network access or credentials.
+//
+// The fake implements the subset of the JSON and XML APIs used by the storage
+// client for object reads, writes, listings, copies, updates and deletes,
+// including generation preconditions, object versions and holds.
+package objectstoretest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// Server is an in-memory fake GCS server.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	uploads    map[string]*upload
+	generation int64
+}
+
+type bucket struct {
+	attrs raw.Bucket
+	// versions holds every generation of every object, oldest first.
+	// The last generation is live unless it has been deleted.
+	versions map[string][]*object
+}
+
+type object struct {
+	attrs raw.Object
+	data  []byte
+}
+
+type upload struct {
+	bucket string
+	attrs  raw.Object
+	query  url.Values
+	data   bytes.Buffer
+}
+
+// NewServer starts a fake GCS server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*upload),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// CreateBucket creates an empty bucket if it doesn't exist.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; ok {
+		return
+	}
+	s.buckets[name] = &bucket{
+		attrs: raw.Bucket{
+			Kind:           "storage#bucket",
+			Id:             name,
+			Name:           name,
+			Metageneration: 1,
+			StorageClass:   "STANDARD",
+			Location:       "US",
+			TimeCreated:    time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		versions: make(map[string][]*object),
+	}
+}
+
+// PutObject stores data as a new generation of the object called name,
+// creating bucket if needed, e.g. to seed the bucket before a test. It
+// returns the generation of the object. Names are object names, see
+// CloudStorage.Filename for the name of a key.
+func (s *Server) PutObject(bucket, name, contentType string, data []byte) int64 {
+	s.CreateBucket(bucket)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, _ := s.insert(s.buckets[bucket], name, raw.Object{ContentType: contentType}, data, url.Values{})
+	return obj.attrs.Generation
+}
+
+// Object returns the payload and generation of the live object called name,
+// and false if it doesn't exist, e.g. to assert what a test wrote.
+func (s *Server) Object(bucket, name string) ([]byte, int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, 0, false
+	}
+	obj, code := b.lookup(url.PathEscape(name), url.Values{})
+	if code != http.StatusOK {
+		return nil, 0, false
+	}
+	return append([]byte(nil), obj.data...), obj.attrs.Generation, true
+}
+
+// ObjectNames returns the names of the live objects under prefix in order.
+func (s *Server) ObjectNames(bucket, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for name, versions := range b.versions {
+		if strings.HasPrefix(name, prefix) && versions[len(versions)-1].attrs.TimeDeleted == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClientOptions returns the options connecting a storage client to the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// NewCloudStorage starts a server with the given bucket and returns a
+// CloudStorage using it. The server is closed when the test finishes.
+func NewCloudStorage(t testing.TB, bucket string, opts ...objectstore.Option) (*objectstore.CloudStorage, *Server) {
+	t.Helper()
+
+	s := NewServer()
+	t.Cleanup(s.Close)
+	s.CreateBucket(bucket)
+
+	opts = append([]objectstore.Option{objectstore.WithClientOptions(s.ClientOptions())}, opts...)
+	cs, err := objectstore.NewCloudStorage(bucket, opts...)
+	if err != nil {
+		t.Fatalf("objectstoretest: %v", err)
+	}
+	return cs, s
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		s.serveUpload(w, r, splitPath(strings.TrimPrefix(path, "/upload/storage/v1/b/")))
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		s.serveJSON(w, r, splitPath(strings.TrimPrefix(path, "/storage/v1/b/")))
+	default:
+		s.serveMedia(w, r, strings.TrimPrefix(path, "/"))
+	}
+}
+
+// serveJSON handles the metadata requests of the JSON API.
+func (s *Server) serveJSON(w http.ResponseWriter, r *http.Request, segments []string) {
+	b, ok := s.buckets[unescape(segments[0])]
+	if !ok {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	q := r.URL.Query()
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, &b.attrs)
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		if err := json.NewDecoder(r.Body).Decode(&b.attrs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		if rp := b.attrs.RetentionPolicy; rp != nil && rp.EffectiveTime == "" {
+			rp.EffectiveTime = now
+		}
+		b.attrs.Metageneration++
+		b.attrs.Updated = now
+		writeJSON(w, &b.attrs)
+	case len(segments) == 2 && segments[1] == "lockRetentionPolicy" && r.Method == http.MethodPost:
+		if b.attrs.RetentionPolicy == nil {
+			writeError(w, http.StatusBadRequest, "bucket has no retention policy")
+			return
+		}
+		b.attrs.RetentionPolicy.IsLocked = true
+		writeJSON(w, &b.attrs)
+	case len(segments) == 2 && segments[1] == "o" && r.Method == http.MethodGet:
+		s.list(w, b, q)
+	case len(segments) == 3 && segments[1] == "o" && r.Method == http.MethodGet:
+		obj, code := b.lookup(segments[2], q)
+		if code == http.StatusOK {
+			code = checkConditions(obj, q, http.StatusNotModified)
+		}
+		if code != http.StatusOK {
+			writeError(w, code, http.StatusText(code))
+			return
+		}
+		writeJSON(w, &obj.attrs)
+	case len(segments) == 3 && segments[1] == "o" && r.Method == http.MethodPatch:
+		s.update(w, r, b, segments[2], q)
+	case len(segments) == 3 && segments[1] == "o" && r.Method == http.MethodDelete:
+		s.delete(w, b, segments[2], q)
+	case len(segments) == 8 && segments[1] == "o" && segments[3] == "rewriteTo" && r.Method == http.MethodPost:
+		s.rewrite(w, r, b, segments[2], segments[5], segments[7], q)
+	case len(segments) == 4 && segments[1] == "o" && segments[3] == "compose" && r.Method == http.MethodPost:
+		s.compose(w, r, b, segments[2], q)
+	default:
+		writeError(w, http.StatusNotImplemented, r.Method+" "+r.URL.Path+" is not supported by the fake")
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, b *bucket, q url.Values) {
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	versions := q.Get("versions") == "true"
+	start, end := q.Get("startOffset"), q.Get("endOffset")
+	token := q.Get("pageToken")
+	var glob *regexp.Regexp
+	if g := q.Get("matchGlob"); g != "" {
+		var err error
+		if glob, err = globRegexp(g); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	max, err := strconv.Atoi(q.Get("maxResults"))
+	if err != nil || max <= 0 || max > 1000 {
+		max = 1000
+	}
+
+	names := make([]string, 0, len(b.versions))
+	for name := range b.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := &raw.Objects{Kind: "storage#objects"}
+	// prefixes up to the page token were returned by previous pages, which
+	// covered all names under them
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name <= token ||
+			(start != "" && name < start) || (end != "" && name >= end) ||
+			(glob != nil && !glob.MatchString(name)) {
+			continue
+		}
+		p := ""
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p = name[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if p != "" && (seen[p] || token != "" && p <= token) {
+			continue
+		}
+		if len(res.Items)+len(res.Prefixes) >= max {
+			// the page token is the last name covered by this page
+			res.NextPageToken = lastBefore(names, name)
+			break
+		}
+
+		if p != "" {
+			seen[p] = true
+			res.Prefixes = append(res.Prefixes, p)
+			continue
+		}
+		for _, obj := range b.versions[name] {
+			if versions || obj.attrs.TimeDeleted == "" {
+				attrs := obj.attrs
+				res.Items = append(res.Items, &attrs)
+			}
+		}
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, b *bucket, name string, q url.Values) {
+	obj, code := b.lookup(name, q)
+	if code == http.StatusOK {
+		code = checkConditions(obj, q, http.StatusPreconditionFailed)
+	}
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if m, ok := patch["metadata"]; ok {
+		// metadata is merged key by key, null values remove keys
+		var metadata map[string]*string
+		if err := json.Unmarshal(m, &metadata); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if metadata == nil {
+			obj.attrs.Metadata = nil
+		}
+		for k, v := range metadata {
+			if obj.attrs.Metadata == nil {
+				obj.attrs.Metadata = make(map[string]string)
+			}
+			if v == nil {
+				delete(obj.attrs.Metadata, k)
+			} else {
+				obj.attrs.Metadata[k] = *v
+			}
+		}
+		delete(patch, "metadata")
+	}
+	data, _ := json.Marshal(patch)
+	if err := json.Unmarshal(data, &obj.attrs); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	obj.attrs.Metageneration++
+	obj.attrs.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	writeJSON(w, &obj.attrs)
+}
+
+func (s *Server) delete(w http.ResponseWriter, b *bucket, name string, q url.Values) {
+	obj, code := b.lookup(name, q)
+	if code == http.StatusOK {
+		code = checkConditions(obj, q, http.StatusPreconditionFailed)
+	}
+	if code == http.StatusOK && (obj.attrs.TemporaryHold || obj.attrs.EventBasedHold) {
+		code = http.StatusForbidden
+	}
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	if q.Get("generation") != "" {
+		// deleting a specific generation removes it permanently
+		versions := b.versions[name]
+		for i, v := range versions {
+			if v == obj {
+				b.versions[name] = append(versions[:i:i], versions[i+1:]...)
+			}
+		}
+		if len(b.versions[name]) == 0 {
+			delete(b.versions, name)
+		}
+	} else {
+		obj.attrs.TimeDeleted = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) rewrite(w http.ResponseWriter, r *http.Request, b *bucket, src, dstBucket, dst string, q url.Values) {
+	obj, code := b.lookup(src, url.Values{"generation": q["sourceGeneration"]})
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+	db, ok := s.buckets[unescape(dstBucket)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+
+	attrs := obj.attrs
+	var override raw.Object
+	if err := json.NewDecoder(r.Body).Decode(&override); err == nil {
+		mergeWriteAttrs(&attrs, &override)
+	}
+	created, code := s.insert(db, unescape(dst), attrs, obj.data, q)
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+	writeJSON(w, &raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		Done:                true,
+		ObjectSize:          int64(len(obj.data)),
+		TotalBytesRewritten: int64(len(obj.data)),
+		Resource:            &created.attrs,
+	})
+}
+
+func (s *Server) compose(w http.ResponseWriter, r *http.Request, b *bucket, dst string, q url.Values) {
+	var req raw.ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var data []byte
+	for _, src := range req.SourceObjects {
+		sq := url.Values{}
+		if src.Generation != 0 {
+			sq.Set("generation", strconv.FormatInt(src.Generation, 10))
+		}
+		obj, code := b.lookup(url.PathEscape(src.Name), sq)
+		if code != http.StatusOK {
+			writeError(w, code, http.StatusText(code))
+			return
+		}
+		data = append(data, obj.data...)
+	}
+
+	var attrs raw.Object
+	if req.Destination != nil {
+		attrs = *req.Destination
+	}
+	created, code := s.insert(b, unescape(dst), attrs, data, q)
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+	writeJSON(w, &created.attrs)
+}
+
+// serveUpload handles multipart and resumable uploads.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, segments []string) {
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		s.resumeUpload(w, r, id)
+		return
+	}
+	if len(segments) != 2 || segments[1] != "o" || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotImplemented, r.Method+" "+r.URL.Path+" is not supported by the fake")
+		return
+	}
+	b, ok := s.buckets[unescape(segments[0])]
+	if !ok {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+
+	switch q.Get("uploadType") {
+	case "multipart":
+		attrs, data, err := readMultipart(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if attrs.Name == "" {
+			attrs.Name = q.Get("name")
+		}
+		obj, code := s.insert(b, attrs.Name, attrs, data, q)
+		if code != http.StatusOK {
+			writeError(w, code, http.StatusText(code))
+			return
+		}
+		writeJSON(w, &obj.attrs)
+	case "resumable":
+		var attrs raw.Object
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if attrs.Name == "" {
+			attrs.Name = q.Get("name")
+		}
+		s.generation++
+		id := strconv.FormatInt(s.generation, 10)
+		s.uploads[id] = &upload{bucket: b.attrs.Name, attrs: attrs, query: q}
+
+		loc := *r.URL
+		lq := loc.Query()
+		lq.Set("upload_id", id)
+		loc.RawQuery = lq.Encode()
+		w.Header().Set("Location", s.URL+loc.RequestURI())
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported uploadType "+q.Get("uploadType"))
+	}
+}
+
+func (s *Server) resumeUpload(w http.ResponseWriter, r *http.Request, id string) {
+	up, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+	if _, err := io.Copy(&up.data, r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Content-Range is "bytes <first>-<last>/<total>" where total is "*"
+	// until the final chunk
+	cr := r.Header.Get("Content-Range")
+	if strings.HasSuffix(cr, "/*") {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		if up.data.Len() > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", up.data.Len()-1))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	delete(s.uploads, id)
+	obj, code := s.insert(s.buckets[up.bucket], up.attrs.Name, up.attrs, up.data.Bytes(), up.query)
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+	writeJSON(w, &obj.attrs)
+}
+
+// serveMedia handles object downloads through the XML API.
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusNotImplemented, r.Method+" "+r.URL.Path+" is not supported by the fake")
+		return
+	}
+	i := strings.Index(path, "/")
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "object not found")
+		return
+	}
+	b, ok := s.buckets[unescape(path[:i])]
+	if !ok {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+
+	q := r.URL.Query()
+	for header, param := range map[string]string{
+		"X-Goog-If-Generation-Match":     "ifGenerationMatch",
+		"X-Goog-If-Metageneration-Match": "ifMetagenerationMatch",
+	} {
+		if v := r.Header.Get(header); v != "" {
+			q.Set(param, v)
+		}
+	}
+	obj, code := b.lookup(path[i+1:], q)
+	if code == http.StatusOK {
+		code = checkConditions(obj, q, http.StatusPreconditionFailed)
+	}
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	data := obj.data
+	h := w.Header()
+	h.Set("Content-Type", obj.attrs.ContentType)
+	if obj.attrs.ContentEncoding != "" {
+		h.Set("Content-Encoding", obj.attrs.ContentEncoding)
+	}
+	if obj.attrs.CacheControl != "" {
+		h.Set("Cache-Control", obj.attrs.CacheControl)
+	}
+	h.Set("X-Goog-Generation", strconv.FormatInt(obj.attrs.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(obj.attrs.Metageneration, 10))
+	h.Set("X-Goog-Hash", "crc32c="+obj.attrs.Crc32c+",md5="+obj.attrs.Md5Hash)
+	if updated, err := time.Parse(time.RFC3339Nano, obj.attrs.Updated); err == nil {
+		h.Set("Last-Modified", updated.Format(http.TimeFormat))
+	}
+
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		from, to, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "invalid range "+rng)
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to-1, len(data)))
+		data = data[from:to]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// insert stores a new generation of the object name, replacing the live one.
+func (s *Server) insert(b *bucket, name string, attrs raw.Object, data []byte, q url.Values) (*object, int) {
+	versions := b.versions[name]
+	var live *object
+	if n := len(versions); n > 0 && versions[n-1].attrs.TimeDeleted == "" {
+		live = versions[n-1]
+	}
+	if code := checkConditions(live, q, http.StatusPreconditionFailed); code != http.StatusOK {
+		return nil, code
+	}
+	if live != nil && (live.attrs.TemporaryHold || live.attrs.EventBasedHold) {
+		return nil, http.StatusForbidden
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	s.generation++
+	md5sum := md5.Sum(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+
+	obj := &object{data: append([]byte(nil), data...)}
+	mergeWriteAttrs(&obj.attrs, &attrs)
+	obj.attrs.Kind = "storage#object"
+	obj.attrs.Bucket = b.attrs.Name
+	obj.attrs.Name = name
+	obj.attrs.Id = fmt.Sprintf("%s/%s/%d", b.attrs.Name, name, s.generation)
+	obj.attrs.Generation = s.generation
+	obj.attrs.Metageneration = 1
+	obj.attrs.Size = uint64(len(data))
+	obj.attrs.Md5Hash = base64.StdEncoding.EncodeToString(md5sum[:])
+	obj.attrs.Crc32c = base64.StdEncoding.EncodeToString(crc)
+	obj.attrs.Etag = strconv.FormatInt(s.generation, 10)
+	obj.attrs.TimeCreated = now
+	obj.attrs.Updated = now
+	obj.attrs.TimeDeleted = ""
+	if obj.attrs.ContentType == "" {
+		obj.attrs.ContentType = "application/octet-stream"
+	}
+	if obj.attrs.StorageClass == "" {
+		obj.attrs.StorageClass = b.attrs.StorageClass
+	}
+
+	if live != nil {
+		live.attrs.TimeDeleted = now
+	}
+	b.versions[name] = append(versions, obj)
+	return obj, http.StatusOK
+}
+
+// lookup finds the live object, or the generation given in q, by its escaped name.
+func (b *bucket) lookup(escaped string, q url.Values) (*object, int) {
+	versions := b.versions[unescape(escaped)]
+	if g := q.Get("generation"); g != "" {
+		for _, v := range versions {
+			if strconv.FormatInt(v.attrs.Generation, 10) == g {
+				return v, http.StatusOK
+			}
+		}
+		return nil, http.StatusNotFound
+	}
+	if n := len(versions); n > 0 && versions[n-1].attrs.TimeDeleted == "" {
+		return versions[n-1], http.StatusOK
+	}
+	return nil, http.StatusNotFound
+}
+
+// checkConditions evaluates the generation preconditions in q against obj,
+// which is nil if the object doesn't exist. notMatch is the status returned
+// when a not-match condition fails.
+func checkConditions(obj *object, q url.Values, notMatch int) int {
+	var generation, metageneration int64
+	if obj != nil {
+		generation, metageneration = obj.attrs.Generation, obj.attrs.Metageneration
+	}
+	if v := q.Get("ifGenerationMatch"); v != "" && v != strconv.FormatInt(generation, 10) {
+		return http.StatusPreconditionFailed
+	}
+	if v := q.Get("ifMetagenerationMatch"); v != "" && v != strconv.FormatInt(metageneration, 10) {
+		return http.StatusPreconditionFailed
+	}
+	if v := q.Get("ifGenerationNotMatch"); v != "" && v == strconv.FormatInt(generation, 10) {
+		return notMatch
+	}
+	if v := q.Get("ifMetagenerationNotMatch"); v != "" && v == strconv.FormatInt(metageneration, 10) {
+		return notMatch
+	}
+	return http.StatusOK
+}
+
+// mergeWriteAttrs copies the attributes settable on write from src to dst.
+func mergeWriteAttrs(dst, src *raw.Object) {
+	if src.ContentType != "" {
+		dst.ContentType = src.ContentType
+	}
+	if src.ContentEncoding != "" {
+		dst.ContentEncoding = src.ContentEncoding
+	}
+	if src.ContentDisposition != "" {
+		dst.ContentDisposition = src.ContentDisposition
+	}
+	if src.ContentLanguage != "" {
+		dst.ContentLanguage = src.ContentLanguage
+	}
+	if src.CacheControl != "" {
+		dst.CacheControl = src.CacheControl
+	}
+	if src.CustomTime != "" {
+		dst.CustomTime = src.CustomTime
+	}
+	if src.StorageClass != "" {
+		dst.StorageClass = src.StorageClass
+	}
+	if src.Metadata != nil {
+		dst.Metadata = src.Metadata
+	}
+	dst.TemporaryHold = dst.TemporaryHold || src.TemporaryHold
+	dst.EventBasedHold = dst.EventBasedHold || src.EventBasedHold
+}
+
+func readMultipart(r *http.Request) (raw.Object, []byte, error) {
+	var attrs raw.Object
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return attrs, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if err := json.NewDecoder(part).Decode(&attrs); err != nil {
+		return attrs, nil, err
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = part.Header.Get("Content-Type")
+	}
+	data, err := ioutil.ReadAll(part)
+	return attrs, data, err
+}
+
+// parseRange parses a single "bytes=" range into a half-open interval.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == rng || i < 0 {
+		return 0, 0, false
+	}
+	first, last := spec[:i], spec[i+1:]
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true
+	}
+	from, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || from > size {
+		return 0, 0, false
+	}
+	to := size
+	if last != "" {
+		if to, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		if to++; to > size {
+			to = size
+		}
+	}
+	return from, to, true
+}
+
+// globRegexp translates a GCS matchGlob pattern into a regexp.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("invalid glob %q", glob)
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += j
+		case '{':
+			j := strings.IndexByte(glob[i:], '}')
+			if j < 0 {
+				return nil, fmt.Errorf("invalid glob %q", glob)
+			}
+			alts := strings.Split(glob[i+1:i+j], ",")
+			for k, alt := range alts {
+				alts[k] = regexp.QuoteMeta(alt)
+			}
+			re.WriteString("(" + strings.Join(alts, "|") + ")")
+			i += j
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// lastBefore returns the greatest name in the sorted names less than name.
+func lastBefore(names []string, name string) string {
+	i := sort.SearchStrings(names, name)
+	if i == 0 {
+		return ""
+	}
+	return names[i-1]
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimSuffix(path, "/"), "/")
+}
+
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"message": message}},
+		},
+	})
+}
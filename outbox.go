@@ -0,0 +1,229 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// metaOutboxState is the state of an outbox record, pending until the write
+// it describes succeeded.
+const metaOutboxState = "objectstore-outbox-state"
+
+const (
+	outboxPending = "pending"
+	outboxReady   = "ready"
+)
+
+// OutboxRecord is an event recorded by an Outbox for a write.
+type OutboxRecord struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+	// Op is "put" or "delete".
+	Op string `json:"op"`
+	// Payload is the JSON encoded object written by a put.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Created time.Time       `json:"created"`
+}
+
+// Outbox writes objects together with a record of the write, which Relay
+// publishes, e.g. to Pub/Sub, giving reliable "write and publish an event"
+// without a database transaction.
+//
+// The record is written as pending before the object and marked ready after
+// it. A record left pending, by a failed write or a crash, is checked against
+// the object by Relay once it is older than the grace period: it's published
+// if the object still is what the record describes, and dropped otherwise.
+// Writes overwritten before such a check are thus only published by the
+// record of the later write. Records dropped while their write was still in
+// progress are written again once the write succeeded.
+type Outbox[T any] struct {
+	cs      *CloudStorage
+	objects *querier[T]
+	prefix  string
+}
+
+// NewOutbox creates an Outbox writing objects like a store created with opts
+// and their records under outboxPrefix, e.g. `_outbox/orders/`.
+func NewOutbox[T any](cs *CloudStorage, outboxPrefix string, opts ...StoreOption) *Outbox[T] {
+	if !strings.HasSuffix(outboxPrefix, "/") {
+		outboxPrefix += "/"
+	}
+	return &Outbox[T]{cs: cs, objects: newQuerier[T](cs, opts...), prefix: outboxPrefix}
+}
+
+// Put writes obj to key like CRUDStore.Put and records the write.
+func (o *Outbox[T]) Put(ctx context.Context, key string, obj T) error {
+	payload, err := o.cs.Marshal(&obj)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	}
+	return o.write(ctx, OutboxRecord{Key: key, Op: "put", Payload: payload}, func() error {
+		return o.objects.Put(ctx, key, obj)
+	})
+}
+
+// Delete deletes the object at key like CRUDStore.Delete and records the
+// delete.
+func (o *Outbox[T]) Delete(ctx context.Context, key string) error {
+	return o.write(ctx, OutboxRecord{Key: key, Op: "delete"}, func() error {
+		return o.objects.Delete(ctx, key)
+	})
+}
+
+func (o *Outbox[T]) write(ctx context.Context, record OutboxRecord, write func() error) error {
+	id, err := sequentialID()
+	if err != nil {
+		return fmt.Errorf("%s %s: outbox: %w", record.Op, record.Key, err)
+	}
+	record.ID = id
+	record.Created = time.Now()
+	data, err := o.cs.Marshal(&record)
+	if err != nil {
+		return fmt.Errorf("%s %s: outbox: %w", record.Op, record.Key, err)
+	}
+	pending := func(w *storage.Writer) { setMetadata(w, metaOutboxState, outboxPending) }
+	err = o.cs.writeFileIf(ctx, o.prefix+id, bytes.NewReader(data), "application/json", storage.Conditions{DoesNotExist: true}, pending)
+	if err != nil {
+		return fmt.Errorf("%s %s: outbox: %w", record.Op, record.Key, err)
+	}
+
+	if err := write(); err != nil {
+		// best effort, Relay drops the record anyway
+		_ = o.cs.deleteFile(ctx, o.prefix+id)
+		return err
+	}
+	if err := o.markReady(ctx, id, data); err != nil {
+		return fmt.Errorf("%s %s: outbox: %w", record.Op, record.Key, err)
+	}
+	return nil
+}
+
+// markReady marks the pending record id as ready. Relay may have dropped the
+// record in the meantime if the write took longer than the grace period, the
+// record is then written again as ready.
+func (o *Outbox[T]) markReady(ctx context.Context, id string, data []byte) error {
+	// only a relay dropping the record changes it while it's pending
+	h := o.cs.bucket.Object(o.cs.Filename(o.prefix + id)).If(storage.Conditions{MetagenerationMatch: 1})
+	_, err := h.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{metaOutboxState: outboxReady}})
+	if err = wrapStorageError(err); err == nil || !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	ready := func(w *storage.Writer) { setMetadata(w, metaOutboxState, outboxReady) }
+	return o.cs.writeFileIf(ctx, o.prefix+id, bytes.NewReader(data), "application/json", storage.Conditions{DoesNotExist: true}, ready)
+}
+
+// Relay publishes the records in the order they were written and deletes
+// them once publish succeeded, until it reaches a record which is pending
+// for less than grace or a publish fails. Records are published at least
+// once, a record is published again if deleting it fails or several relays
+// run concurrently. It returns the number of published records. Relay is
+// meant to run periodically, e.g.
+//
+//	n, err := outbox.Relay(ctx, time.Minute, func(ctx context.Context, r OutboxRecord) error {
+//		_, err := topic.Publish(ctx, &pubsub.Message{
+//			Data:        r.Payload,
+//			OrderingKey: r.Key,
+//			Attributes:  map[string]string{"key": r.Key, "op": r.Op},
+//		}).Get(ctx)
+//		return err
+//	})
+func (o *Outbox[T]) Relay(ctx context.Context, grace time.Duration, publish func(context.Context, OutboxRecord) error) (int, error) {
+	published := 0
+	it := o.cs.listNames(ctx, o.cs.FilenamePrefix(o.prefix), "Name", "Metadata", "Metageneration", "Created")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return published, nil
+		} else if err != nil {
+			return published, fmt.Errorf("Relay: %w", err)
+		}
+		key, ok := o.cs.Key(attrs.Name)
+		if !ok || !strings.HasPrefix(key, o.prefix) {
+			continue
+		}
+
+		record, err := o.record(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue // relayed by someone else
+		} else if err != nil {
+			return published, fmt.Errorf("Relay %s: %w", key, err)
+		}
+		if attrs.Metadata[metaOutboxState] != outboxReady {
+			if time.Since(attrs.Created) < grace {
+				// later records must wait to keep the order
+				return published, nil
+			}
+			happened, err := o.happened(ctx, record)
+			if err != nil {
+				return published, fmt.Errorf("Relay %s: %w", key, err)
+			}
+			if !happened {
+				// unless the write landed and marked it ready in the meantime
+				conds := storage.Conditions{MetagenerationMatch: attrs.Metageneration}
+				err := o.cs.deleteFileIf(ctx, key, conds)
+				if isPreconditionFailed(err) {
+					// it's ready now, the next run publishes it in order
+					return published, nil
+				} else if err != nil && !errors.Is(err, ErrObjectNotFound) {
+					return published, fmt.Errorf("Relay %s: %w", key, err)
+				}
+				continue
+			}
+		}
+
+		if err := publish(ctx, *record); err != nil {
+			return published, fmt.Errorf("Relay %s: publish: %w", key, err)
+		}
+		published++
+		if err := o.cs.deleteFile(ctx, key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return published, fmt.Errorf("Relay %s: %w", key, err)
+		}
+	}
+}
+
+func (o *Outbox[T]) record(ctx context.Context, key string) (*OutboxRecord, error) {
+	data, err := o.cs.GetFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var record OutboxRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// happened reports whether the object is in the state the write of a pending
+// record left it in.
+func (o *Outbox[T]) happened(ctx context.Context, record *OutboxRecord) (bool, error) {
+	obj, err := o.objects.Get(ctx, record.Key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return record.Op == "delete", nil
+	} else if err != nil {
+		return false, err
+	} else if record.Op == "delete" {
+		return false, nil
+	}
+	// compare decoded values, the encoder options may differ in escaping
+	// and indentation
+	current, err := normalizeJSON(obj)
+	if err != nil {
+		return false, err
+	}
+	var written interface{}
+	dec := json.NewDecoder(bytes.NewReader(record.Payload))
+	dec.UseNumber()
+	if err := dec.Decode(&written); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(current, written), nil
+}
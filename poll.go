@@ -0,0 +1,114 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ChangeType is the kind of change to an object.
+type ChangeType int
+
+const (
+	ObjectCreated ChangeType = iota + 1
+	ObjectUpdated
+	ObjectDeleted
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ObjectCreated:
+		return "created"
+	case ObjectUpdated:
+		return "updated"
+	case ObjectDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+// ChangeEvent describes a change to an object.
+type ChangeEvent struct {
+	Type ChangeType
+	Name string
+	// Key is the key of the object, empty if the name doesn't match the
+	// filename format.
+	Key string
+	// Generation is the new generation, or the last seen one for deletes.
+	Generation int64
+}
+
+// Poll lists prefix every interval and calls fn for every object created,
+// replaced or deleted since the previous listing, for environments where
+// bucket notifications can't be configured. The first listing only records
+// the current state. Poll blocks until ctx is done, listing fails or fn
+// returns an error.
+func (cs *CloudStorage) Poll(ctx context.Context, prefix string, interval time.Duration, fn func(ChangeEvent) error) error {
+	seen, err := cs.generations(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("Poll %s: %w", prefix, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current, err := cs.generations(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("Poll %s: %w", prefix, err)
+		}
+		for name, generation := range current {
+			previous, ok := seen[name]
+			if !ok {
+				err = fn(cs.changeEvent(ObjectCreated, name, generation))
+			} else if previous != generation {
+				err = fn(cs.changeEvent(ObjectUpdated, name, generation))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for name, generation := range seen {
+			if _, ok := current[name]; !ok {
+				if err := fn(cs.changeEvent(ObjectDeleted, name, generation)); err != nil {
+					return err
+				}
+			}
+		}
+		seen = current
+	}
+}
+
+// generations lists the live generation of every object under prefix.
+func (cs *CloudStorage) generations(ctx context.Context, prefix string) (map[string]int64, error) {
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Generation"}); err != nil {
+		return nil, err
+	}
+
+	generations := make(map[string]int64)
+	it := cs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return generations, nil
+		} else if err != nil {
+			return nil, err
+		}
+		generations[attrs.Name] = attrs.Generation
+	}
+}
+
+func (cs *CloudStorage) changeEvent(t ChangeType, name string, generation int64) ChangeEvent {
+	key, _ := cs.Key(name)
+	return ChangeEvent{Type: t, Name: name, Key: key, Generation: generation}
+}
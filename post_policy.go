@@ -0,0 +1,47 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// PostPolicyConditions constrains the uploads made with a signed POST policy.
+type PostPolicyConditions struct {
+	// Expires is when the policy stops being accepted.
+	Expires time.Time
+
+	// ContentType is the only content type accepted, if set.
+	ContentType string
+
+	// MinSize and MaxSize bound the upload size in bytes. A MaxSize of 0
+	// means no upper bound.
+	MinSize uint64
+	MaxSize uint64
+}
+
+// GenerateSignedPostPolicy creates a signed POST policy letting browsers
+// upload directly to the object at key. The policy is signed with the
+// credentials of the storage client.
+func (cs *CloudStorage) GenerateSignedPostPolicy(ctx context.Context, key string, conds PostPolicyConditions) (*storage.PostPolicyV4, error) {
+	opts := &storage.PostPolicyV4Options{
+		Expires: conds.Expires,
+		Fields:  &storage.PolicyV4Fields{ContentType: conds.ContentType},
+	}
+	if conds.MinSize > 0 || conds.MaxSize > 0 {
+		max := conds.MaxSize
+		if max == 0 {
+			max = math.MaxInt64
+		}
+		opts.Conditions = append(opts.Conditions, storage.ConditionContentLengthRange(conds.MinSize, max))
+	}
+
+	policy, err := cs.bucket.GenerateSignedPostPolicyV4(cs.Filename(key), opts)
+	if err != nil {
+		return nil, fmt.Errorf("GenerateSignedPostPolicy %s: %w", key, err)
+	}
+	return policy, nil
+}
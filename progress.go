@@ -0,0 +1,103 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TransferProgressFunc is called while an object is uploaded or downloaded
+// with the number of bytes transferred so far. total is the size of the
+// object, or -1 if it isn't known up front.
+type TransferProgressFunc func(transferred, total int64)
+
+// WriteFileWithProgress creates the object at key like WriteFile, calling
+// progress as the payload is handed to the upload. The total is known if
+// reader has a Size method, like bytes.Reader and strings.Reader.
+// Callbacks stopping for long is a sign of a stuck upload.
+func (cs *CloudStorage) WriteFileWithProgress(ctx context.Context, key string, reader io.Reader, progress TransferProgressFunc) error {
+	return cs.WriteFile(ctx, key, newProgressReader(reader, progress))
+}
+
+// GetFileWithProgress downloads the object at key like GetFile, calling
+// progress as the payload is received.
+func (cs *CloudStorage) GetFileWithProgress(ctx context.Context, key string, progress TransferProgressFunc) (_ []byte, err error) {
+	defer cs.finish("Get", key, &err)
+	defer cs.measure("Get", time.Now())
+
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get %s: %w", key, err)
+	}
+	defer release()
+
+	reader, err := cs.newReader(ctx, cs.readObject(cs.Filename(key)))
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, fmt.Errorf("Get %s: %w", key, err2)
+	}
+	defer reader.Close()
+
+	data, err := readAll(ctx, newProgressReader(reader, progress), reader.Attrs.Size)
+	if err != nil {
+		return nil, fmt.Errorf("Get %s: readall: %w", key, err)
+	}
+	return data, nil
+}
+
+// readAll reads r until EOF, giving up as soon as ctx is done instead of
+// waiting for the next read to fail. size preallocates the buffer.
+func readAll(ctx context.Context, r io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		size = 0
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	_, err := io.Copy(buf, contextReader{ctx, r})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// newProgressReader wraps r to report the bytes read from it to progress.
+func newProgressReader(r io.Reader, progress TransferProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	pr := &progressReader{r: r, total: -1, progress: progress}
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		pr.total = s.Size()
+		return sizedReader{pr, pr.total}
+	}
+	return pr
+}
+
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	total    int64
+	progress TransferProgressFunc
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.n += int64(n)
+		pr.progress(pr.n, pr.total)
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrPublicAccessForbidden is returned by MakePublic, and GrantACL to public
+// entities, on a CloudStorage configured with WithForbidPublicAccess.
+var ErrPublicAccessForbidden = errors.New("public access is forbidden")
+
+// ErrUniformBucketLevelAccess is returned by object ACL operations on buckets
+// with uniform bucket-level access, which only support bucket IAM policies.
+var ErrUniformBucketLevelAccess = errors.New("bucket has uniform bucket-level access")
+
+// MakePublic grants allUsers read access to the object at key, e.g. to serve
+// it from PublicURL.
+func (cs *CloudStorage) MakePublic(ctx context.Context, key string) (err error) {
+	defer cs.finish("MakePublic", key, &err)
+
+	if cs.forbidpublic {
+		return fmt.Errorf("MakePublic %s: %w", key, ErrPublicAccessForbidden)
+	}
+	err = cs.bucket.Object(cs.Filename(key)).ACL().Set(ctx, storage.AllUsers, storage.RoleReader)
+	if err2 := cs.wrapUniformAccessError(ctx, wrapStorageError(withDetails("MakePublic", key, err))); err2 != nil {
+		return fmt.Errorf("MakePublic %s: %w", key, err2)
+	}
+	return nil
+}
+
+// MakePrivate revokes the read access of allUsers to the object at key.
+// Objects which weren't public are left as is.
+func (cs *CloudStorage) MakePrivate(ctx context.Context, key string) (err error) {
+	defer cs.finish("MakePrivate", key, &err)
+
+	err = cs.bucket.Object(cs.Filename(key)).ACL().Delete(ctx, storage.AllUsers)
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		// a missing ACL entry is reported like a missing object
+		if _, aerr := cs.bucket.Object(cs.Filename(key)).Attrs(ctx); aerr == nil {
+			return nil
+		}
+	}
+	if err2 := cs.wrapUniformAccessError(ctx, wrapStorageError(withDetails("MakePrivate", key, err))); err2 != nil {
+		return fmt.Errorf("MakePrivate %s: %w", key, err2)
+	}
+	return nil
+}
+
+// wrapUniformAccessError masks err with ErrUniformBucketLevelAccess if it was
+// caused by uniform bucket-level access. GCS reports it as a plain 400, so the
+// bucket attributes are checked.
+func (cs *CloudStorage) wrapUniformAccessError(ctx context.Context, err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusBadRequest {
+		return err
+	}
+	attrs, aerr := cs.bucket.Attrs(ctx)
+	if aerr == nil && attrs.UniformBucketLevelAccess.Enabled {
+		return &storageError{cause: err, mask: ErrUniformBucketLevelAccess}
+	}
+	return err
+}
@@ -7,106 +7,630 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 )
 
-var ErrObjectNotFound = errors.New("object not found")
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrNotModified    = errors.New("object not modified")
+	// ErrGenerationMismatch is returned by conditional operations if the
+	// object was changed by someone else.
+	ErrGenerationMismatch = errors.New("object generation mismatch")
+	// ErrObjectExists is returned by Create if the object already exists.
+	ErrObjectExists = errors.New("object already exists")
+	// ErrInvalidGeneration is returned by DeleteIfGeneration for generations
+	// which no object can have, as zero would otherwise delete unconditionally.
+	ErrInvalidGeneration = errors.New("invalid generation")
+)
+
+// casAttempts is how many times read-modify-write operations such as Patch
+// re-read the object when it changes between reading and writing it back,
+// unless configured WithRetryBudget.
+const casAttempts = 3
 
 // CRUDStore defines a rudimentary typesafe Create, Get, Put, Delete datastore
 // over a CloudStorage.
 // ErrObjectNotFound is returned if an operation is called on a non-existant object.
+// ErrObjectExists is returned by Create if the object exists, and
+// ErrGenerationMismatch by writes if the object changed concurrently.
 type CRUDStore[T any] interface {
 	Create(context.Context, string, T) error
 	Get(context.Context, string) (*T, error)
+	GetOrCreate(context.Context, string, func() (T, error)) (*T, bool, error)
+	GetEntry(context.Context, string) (*Entry[T], error)
+	GetIfChanged(context.Context, string, int64) (*T, int64, error)
+	GetAsOf(context.Context, string, time.Time) (*T, error)
+	GetField(ctx context.Context, key, pointer string) (json.RawMessage, error)
 	Put(context.Context, string, T) error
+	Set(context.Context, string, T) error
+	Patch(context.Context, string, json.RawMessage) (*T, error)
+	Swap(context.Context, string, T) (*T, error)
 	Delete(context.Context, string) error
+	DeleteIfGeneration(context.Context, string, int64) error
 	List(context.Context, string) *storage.ObjectIterator
+	ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator
+	ListGlob(context.Context, string) *storage.ObjectIterator
+	ListRange(ctx context.Context, start, end string) *storage.ObjectIterator
+
+	GetAll(context.Context, string, ProgressFunc) ([]Entry[T], error)
+	ForEach(ctx context.Context, prefix string, workers int, fn func(key string, obj *T) error) error
+	DeleteAll(context.Context, string, ProgressFunc) error
+	Prefetch(context.Context, string) error
 }
 
 // querier implements the CRUDStore interface.
 type querier[T any] struct {
-	cs *CloudStorage
+	cs    *CloudStorage
+	cfg   storeConfig
+	cache *generationCache[T]
+	locks *keyLocks
+	gets  *flightGroup[T]
+	codec Codec
+
+	readLimit  *bandwidthLimiter
+	writeLimit *bandwidthLimiter
+	hints      *generationHints
+}
+
+func NewCRUDStore[T any](cs *CloudStorage, opts ...StoreOption) CRUDStore[T] {
+	return newQuerier[T](cs, opts...)
+}
+
+func newQuerier[T any](cs *CloudStorage, opts ...StoreOption) *querier[T] {
+	cfg := newStoreConfig(opts)
+
+	q := &querier[T]{
+		cs:         cs,
+		cfg:        cfg,
+		codec:      cfg.codec,
+		readLimit:  newBandwidthLimiter(cfg.readBandwidth),
+		writeLimit: newBandwidthLimiter(cfg.writeBandwidth),
+	}
+	if q.codec == nil {
+		q.codec = jsonCodec{cs}
+	}
+	if cfg.generationCache {
+		q.cache = newGenerationCache[T](cfg)
+	}
+	if cfg.serializeWrites {
+		q.locks = newKeyLocks()
+	}
+	if cfg.singleflight {
+		q.gets = newFlightGroup[T]()
+	}
+	if cfg.generationHints > 0 {
+		q.hints = newGenerationHints(cfg.generationHints)
+	}
+	return q
+}
+
+// StoreOption configures a CRUDStore.
+//
+//	WithGenerationCache
+//	WithConcurrency
+//	WithOrderedResults
+//	WithSerializedWrites
+//	WithSingleflight
+//	WithStaleTolerance
+//	WithBackgroundRefresh
+//	WithCodec
+//	WithReadBandwidth
+//	WithWriteBandwidth
+//	WithRetryBudget
+//	WithStreamingDecode
+//	WithListAttrSelection
+//	WithMaxObjectSize
+//	WithQuotas
+//	WithIntegrityCheck
+//	WithIdempotentDelete
+//	WithGenerationHints
+type StoreOption interface {
+	applyStore(*storeConfig)
+}
+
+type storeConfig struct {
+	generationCache  bool
+	concurrency      int
+	ordered          bool
+	serializeWrites  bool
+	singleflight     bool
+	staleTolerance   time.Duration
+	refreshInterval  time.Duration
+	onNewGeneration  func(key string, generation int64)
+	codec            Codec
+	readBandwidth    int64
+	writeBandwidth   int64
+	retryBudget      *RetryBudget
+	streamingDecode  bool
+	listAttrs        []string
+	maxObjectSize    int64
+	quotas           *Quotas
+	integrityCheck   bool
+	idempotentDelete bool
+	generationHints  time.Duration
+}
+
+func (cfg storeConfig) retries() RetryBudget {
+	if cfg.retryBudget == nil {
+		return defaultRetryBudget
+	}
+	return *cfg.retryBudget
+}
+
+// deleteError swallows ErrObjectNotFound from deletes WithIdempotentDelete.
+func (cfg storeConfig) deleteError(err error) error {
+	if cfg.idempotentDelete && errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (cfg storeConfig) workers() int {
+	if cfg.concurrency < 1 {
+		return 1
+	}
+	return cfg.concurrency
+}
+
+func newStoreConfig(opts []StoreOption) storeConfig {
+	var cfg storeConfig
+	for _, opt := range opts {
+		opt.applyStore(&cfg)
+	}
+	return cfg
+}
+
+// WithGenerationCache makes the store remember decoded objects together with
+// their generation. Every Get then issues a conditional read and only
+// downloads the object if it has changed.
+// Defaults to `false`
+type WithGenerationCache bool
+
+// WithConcurrency sets the number of goroutines downloading and decoding
+// objects in bulk operations such as GetAll.
+// Defaults to `1`
+type WithConcurrency int
+
+// WithOrderedResults makes bulk operations return objects in listing order
+// even when they are downloaded concurrently.
+// Defaults to `false`
+type WithOrderedResults bool
+
+// WithSerializedWrites makes writes to the same key from this process wait for
+// each other instead of racing and failing their preconditions. Writes from
+// other processes are still only guarded by preconditions.
+// Defaults to `false`
+type WithSerializedWrites bool
+
+// WithSingleflight makes concurrent Gets of the same key share one download.
+// The shared download uses the context of the first caller.
+// Defaults to `false`
+type WithSingleflight bool
+
+// WithStaleTolerance lets the generation cache serve objects validated less
+// than the given duration ago without any request, refreshing them in the
+// background. Only used together with WithGenerationCache.
+// Defaults to `0`, validating on every Get
+type WithStaleTolerance time.Duration
+
+// WithReadBandwidth caps the bytes per second the store downloads, shared by
+// all its reads, e.g. to keep background exports from saturating egress
+// shared with latency-sensitive traffic.
+// Defaults to `0`, unlimited
+type WithReadBandwidth int64
+
+// WithWriteBandwidth caps the bytes per second the store uploads, shared by
+// all its writes.
+// Defaults to `0`, unlimited
+type WithWriteBandwidth int64
+
+// WithStreamingDecode makes Get decode JSON objects while they are downloaded
+// instead of reading them into memory first, halving peak memory for large
+// objects. Unlike json.Unmarshal, data trailing the first JSON value is
+// ignored. Only used with the default codec.
+// Defaults to `false`
+type WithStreamingDecode bool
+
+// WithListAttrSelection makes the listings of the store only fetch the given
+// attributes, e.g. `Name`, `Size` and `Updated`, which speeds up listing
+// prefixes with many objects. See storage.Query.SetAttrSelection for the
+// attribute names. Invalid selections fetch all attributes.
+// Defaults to all attributes
+type WithListAttrSelection []string
+
+// WithMaxObjectSize rejects writes of payloads larger than the given number
+// of bytes with ErrObjectTooLarge. Encoded objects and payloads of known size
+// are rejected before uploading anything.
+// Defaults to `0`, unlimited
+type WithMaxObjectSize int64
+
+// WithIntegrityCheck records the SHA-256 of every payload the store writes in
+// the object metadata and verifies it on reads, failing with ErrIntegrity if
+// the object was corrupted or modified out of band. Objects without a
+// recorded hash fail as well. Verifying costs a metadata request per read.
+// Defaults to `false`
+type WithIntegrityCheck bool
+
+// WithIdempotentDelete makes Delete of a missing object succeed instead of
+// returning ErrObjectNotFound, for callers treating it as already deleted.
+// Defaults to `false`
+type WithIdempotentDelete bool
+
+// WithGenerationHints makes the store remember the generations of objects it
+// read or wrote for the given duration, so Put can use them as precondition
+// instead of reading the attributes first. Hints which turned stale cost a
+// failed write, after which Put falls back to reading the attributes.
+// Defaults to `0`, no hints
+type WithGenerationHints time.Duration
+
+// WithRetryBudget bounds the retries of read-modify-write operations such as
+// Patch and Swap by attempts and time, see RetryBudget.
+// Defaults to 3 attempts without backoff
+func WithRetryBudget(budget RetryBudget) StoreOption { return withRetryBudget{budget} }
+
+type withRetryBudget struct{ budget RetryBudget }
+
+func (o withRetryBudget) applyStore(cfg *storeConfig) { cfg.retryBudget = &o.budget }
+
+// WithCodec sets the Codec encoding the objects of the store, e.g. a
+// MigrationCodec while moving to a new format.
+// Defaults to JSON using the options of the CloudStorage
+func WithCodec(codec Codec) StoreOption { return withCodec{codec} }
+
+type withCodec struct{ codec Codec }
+
+func (o withCodec) applyStore(cfg *storeConfig) { cfg.codec = o.codec }
+
+// WithBackgroundRefresh makes the generation cache serve cached objects
+// without any request and refresh them every interval in the background
+// instead, e.g. for configuration objects read on every request. onChange is
+// called with the new generation whenever a refresh observes one, or 0 if
+// the object was deleted, and may be nil. Writes through the store itself
+// evict the object instead and aren't reported. Only used together with
+// WithGenerationCache, and takes precedence over WithStaleTolerance.
+// Defaults to no background refresh
+func WithBackgroundRefresh(interval time.Duration, onChange func(key string, generation int64)) StoreOption {
+	return withBackgroundRefresh{interval, onChange}
+}
+
+type withBackgroundRefresh struct {
+	interval time.Duration
+	onChange func(string, int64)
 }
 
-func NewCRUDStore[T any](cs *CloudStorage) CRUDStore[T] {
-	return &querier[T]{cs}
+func (o withBackgroundRefresh) applyStore(cfg *storeConfig) {
+	cfg.refreshInterval = o.interval
+	cfg.onNewGeneration = o.onChange
 }
 
+// WithQuotas makes Create, Put and Delete maintain the usage tracked by
+// quotas and rejects writes exceeding them with ErrQuotaExceeded. The same
+// Quotas can be shared by several stores.
+// Defaults to no quotas
+func WithQuotas(quotas *Quotas) StoreOption { return withQuotas{quotas} }
+
+type withQuotas struct{ quotas *Quotas }
+
+func (o withQuotas) applyStore(cfg *storeConfig) { cfg.quotas = o.quotas }
+
+func (o WithGenerationCache) applyStore(cfg *storeConfig)   { cfg.generationCache = bool(o) }
+func (o WithConcurrency) applyStore(cfg *storeConfig)       { cfg.concurrency = int(o) }
+func (o WithOrderedResults) applyStore(cfg *storeConfig)    { cfg.ordered = bool(o) }
+func (o WithSerializedWrites) applyStore(cfg *storeConfig)  { cfg.serializeWrites = bool(o) }
+func (o WithSingleflight) applyStore(cfg *storeConfig)      { cfg.singleflight = bool(o) }
+func (o WithStaleTolerance) applyStore(cfg *storeConfig)    { cfg.staleTolerance = time.Duration(o) }
+func (o WithStreamingDecode) applyStore(cfg *storeConfig)   { cfg.streamingDecode = bool(o) }
+func (o WithListAttrSelection) applyStore(cfg *storeConfig) { cfg.listAttrs = o }
+func (o WithMaxObjectSize) applyStore(cfg *storeConfig)     { cfg.maxObjectSize = int64(o) }
+func (o WithIntegrityCheck) applyStore(cfg *storeConfig)    { cfg.integrityCheck = bool(o) }
+func (o WithIdempotentDelete) applyStore(cfg *storeConfig)  { cfg.idempotentDelete = bool(o) }
+func (o WithGenerationHints) applyStore(cfg *storeConfig)   { cfg.generationHints = time.Duration(o) }
+func (o WithReadBandwidth) applyStore(cfg *storeConfig)     { cfg.readBandwidth = int64(o) }
+func (o WithWriteBandwidth) applyStore(cfg *storeConfig)    { cfg.writeBandwidth = int64(o) }
+
 // Create
-func (q *querier[T]) Create(ctx context.Context, key string, obj T) error {
-	data, err := json.Marshal(&obj)
+func (q *querier[T]) Create(ctx context.Context, key string, obj T) (err error) {
+	defer q.cs.finish("Create", key, &err)
+
+	data, err := q.marshal(&obj)
 	if err != nil {
-		return err
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	defer q.locks.lock(key)()
+	q.cache.evict(key)
+
+	body, settle, err := q.cfg.quotas.reserveWrite(key, bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
 	}
-	return q.cs.WriteFile(ctx, key, bytes.NewReader(data))
+	conds := storage.Conditions{DoesNotExist: true}
+	track, written := q.hints.track(key)
+	err = q.cs.writeFileIf(ctx, key, q.writeLimit.reader(ctx, body), q.codec.ContentType(), conds, append(q.writeAttrs(data), track)...)
+	if settle(err); err != nil {
+		return fmt.Errorf("Create %s: %w", key, err)
+	}
+	written()
+	return nil
 }
 
 // Get
-func (q *querier[T]) Get(ctx context.Context, key string) (*T, error) {
-	data, err := q.cs.GetFile(ctx, key)
-	if err != nil {
-		return nil, fmt.Errorf("Get %s: readall: %w", key, err)
+func (q *querier[T]) Get(ctx context.Context, key string) (_ *T, err error) {
+	defer q.cs.finish("Get", key, &err)
+	return q.gets.do(key, func() (*T, error) { return q.get(ctx, key) })
+}
+
+func (q *querier[T]) get(ctx context.Context, key string) (*T, error) {
+	if q.cache != nil {
+		return q.cache.get(ctx, key, q.GetIfChanged)
 	}
 
-	var obj T
-	if err := json.Unmarshal(data, &obj); err != nil {
+	reader, err := q.open(ctx, key, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Get %s: %w", key, err)
+	}
+	obj, err := q.read(ctx, key, reader)
+	if err != nil {
 		return nil, fmt.Errorf("Get %s: %w", key, err)
 	}
+	return obj, nil
+}
+
+// GetIfChanged only downloads the object if its generation differs from the
+// known generation, returning ErrNotModified otherwise. A known generation of 0
+// always downloads. The current generation is returned with the object.
+func (q *querier[T]) GetIfChanged(ctx context.Context, key string, generation int64) (_ *T, _ int64, err error) {
+	defer q.cs.finish("Get", key, &err)
+
+	reader, err := q.open(ctx, key, generation)
+	if err != nil {
+		return nil, generation, fmt.Errorf("GetIfChanged %s: %w", key, err)
+	}
+	obj, err := q.read(ctx, key, reader)
+	if err != nil {
+		return nil, generation, fmt.Errorf("GetIfChanged %s: %w", key, err)
+	}
+	return obj, reader.Attrs.Generation, nil
+}
+
+// openedReader is a reader holding an operation slot until it is closed.
+// Reads go through body, which verifies the payload WithIntegrityCheck.
+type openedReader struct {
+	*storage.Reader
+	body    io.Reader
+	release func()
+}
 
-	return &obj, nil
+func (r openedReader) Read(p []byte) (int, error) { return r.body.Read(p) }
+
+func (r openedReader) Close() error {
+	defer r.release()
+	return r.Reader.Close()
+}
+
+// open opens a reader on key like openIfChanged, holding an operation slot
+// of the CloudStorage while the download is running. Reads are verified and
+// limited to the read bandwidth of the store.
+func (q *querier[T]) open(ctx context.Context, key string, generation int64) (openedReader, error) {
+	release, err := q.cs.acquire(ctx)
+	if err != nil {
+		return openedReader{}, err
+	}
+	reader, err := q.cs.openIfChanged(ctx, key, generation)
+	if err != nil {
+		release()
+		return openedReader{}, err
+	}
+	body, err := q.verifying(ctx, key, reader)
+	if err != nil {
+		reader.Close()
+		release()
+		return openedReader{}, err
+	}
+	q.hints.set(key, reader.Attrs.Generation)
+	return openedReader{reader, q.readLimit.reader(ctx, body), release}, nil
+}
+
+// read decodes the object from reader and closes it. With WithStreamingDecode
+// JSON objects are decoded while they are downloaded instead of buffering
+// the payload first.
+func (q *querier[T]) read(ctx context.Context, key string, reader openedReader) (*T, error) {
+	var r io.Reader = reader
+
+	if _, ok := q.codec.(jsonCodec); ok && q.cfg.streamingDecode {
+		defer reader.Close()
+		var obj T
+		if err := json.NewDecoder(r).Decode(&obj); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		if q.cfg.integrityCheck {
+			// the payload is only verified once it was read to the end
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return nil, err
+			}
+		}
+		return &obj, nil
+	}
+
+	data, err := ioutil.ReadAll(r)
+	// close before decoding, which may write back migrated encodings
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("readall: %w", err)
+	}
+	return q.decode(ctx, key, data, reader.Attrs.Generation)
 }
 
 // List
 func (q *querier[T]) List(ctx context.Context, prefix string) *storage.ObjectIterator {
-	return q.cs.bucket.Objects(ctx, &storage.Query{
-		Prefix:     prefix,
-		Projection: storage.ProjectionNoACL, // skip some metadata to speed up
-	})
+	return q.cs.list(ctx, prefix, q.cfg.listAttrs...)
 }
 
-// Put
-func (q *querier[T]) Put(ctx context.Context, key string, obj T) error {
-	o := q.cs.bucket.Object(q.cs.Filename(key))
+// ListDelimited lists the immediate children of prefix, e.g. with delimiter
+// `/`. Synthetic subdirectories have only the Prefix attribute set.
+func (q *querier[T]) ListDelimited(ctx context.Context, prefix, delimiter string) *storage.ObjectIterator {
+	return q.cs.listDelimited(ctx, prefix, delimiter, q.cfg.listAttrs...)
+}
 
-	// add compare-and-swap style updating so we don't overwrite with stale read
-	attrs, err := o.Attrs(ctx)
-	if err == nil {
-		o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
-	} else if !errors.Is(err, storage.ErrObjectNotExist) {
-		return fmt.Errorf("Put %s: Attrs: %w", key, err)
-	}
+// ListGlob lists the objects whose names match the glob, e.g.
+// `users/*/settings.json`. `*` doesn't match `/` while `**` does.
+func (q *querier[T]) ListGlob(ctx context.Context, glob string) *storage.ObjectIterator {
+	return q.cs.listGlob(ctx, glob, q.cfg.listAttrs...)
+}
 
-	writer := o.NewWriter(ctx)
-	writer.ContentType = "application/json"
+// ListRange lists the objects with names in the lexicographic range
+// [start, end), e.g. between two timestamps of time-prefixed keys. An empty
+// end lists everything from start.
+func (q *querier[T]) ListRange(ctx context.Context, start, end string) *storage.ObjectIterator {
+	return q.cs.listRange(ctx, start, end, q.cfg.listAttrs...)
+}
+
+// Put
+func (q *querier[T]) Put(ctx context.Context, key string, obj T) (err error) {
+	defer q.cs.finish("Put", key, &err)
+	defer q.locks.lock(key)()
+	q.cache.evict(key)
 
-	if data, err := json.Marshal(&obj); err != nil {
+	data, err := q.marshal(&obj)
+	if err != nil {
 		return fmt.Errorf("Put %s: %w", key, err)
-	} else if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
-		return fmt.Errorf("Put %s: copy: %w", key, err)
 	}
-	if err := writer.Close(); err != nil {
-		// NOTE (Axel): Close()ing will commit any data written, so only do it in the happy path
-		return fmt.Errorf("Put %s: Close: %w", key, err)
+	generation := q.hints.get(key)
+	previous, err := q.cfg.quotas.previous(ctx, key)
+	if err != nil {
+		return fmt.Errorf("Put %s: %w", key, err)
+	} else if q.cfg.quotas != nil {
+		// write exactly the generation accounted for
+		generation = 0
+		if previous != nil {
+			generation = previous.Generation
+		}
 	}
+	track, written := q.hints.track(key)
+	write := func(generation int64) error {
+		body, settle, err := q.cfg.quotas.reserveWrite(key, bytes.NewReader(data), previous)
+		if err != nil {
+			return fmt.Errorf("Put %s: %w", key, err)
+		}
+		err = q.cs.putFile(ctx, key, q.writeLimit.reader(ctx, body), q.codec.ContentType(), generation, append(q.writeAttrs(data), track)...)
+		settle(err)
+		return err
+	}
+	err = write(generation)
+	if generation != 0 && q.cfg.quotas == nil && errors.Is(err, ErrGenerationMismatch) {
+		// the hint is stale, fall back to reading the current generation
+		q.hints.evict(key)
+		err = write(0)
+	}
+	if err != nil {
+		return err
+	}
+	written()
+	return nil
+}
+
+// Set writes obj to key whether or not it exists, without checking that it
+// is unchanged since it was read. Concurrent writes are last-writer-wins, use
+// Put or Swap where losing an update matters.
+func (q *querier[T]) Set(ctx context.Context, key string, obj T) (err error) {
+	defer q.cs.finish("Set", key, &err)
+
+	data, err := q.marshal(&obj)
+	if err != nil {
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	defer q.locks.lock(key)()
+	q.cache.evict(key)
 
+	previous, err := q.cfg.quotas.previous(ctx, key)
+	if err != nil {
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	body, settle, err := q.cfg.quotas.reserveWrite(key, bytes.NewReader(data), previous)
+	if err != nil {
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	track, written := q.hints.track(key)
+	err = q.cs.writeFileIf(ctx, key, q.writeLimit.reader(ctx, body), q.codec.ContentType(), storage.Conditions{}, append(q.writeAttrs(data), track)...)
+	if settle(err); err != nil {
+		q.hints.evict(key)
+		return fmt.Errorf("Set %s: %w", key, err)
+	}
+	written()
 	return nil
 }
 
 // Delete
-func (q *querier[T]) Delete(ctx context.Context, key string) error {
-	err := q.cs.bucket.Object(q.cs.Filename(key)).Delete(ctx)
-	if err2 := wrapStorageError(err); err2 != nil {
-		return fmt.Errorf("Delete %s: %w", key, err2)
-	} else if err != nil {
+func (q *querier[T]) Delete(ctx context.Context, key string) (err error) {
+	defer q.cs.finish("Delete", key, &err)
+	defer q.locks.lock(key)()
+	q.cache.evict(key)
+	q.hints.evict(key)
+	previous, err := q.cfg.quotas.previous(ctx, key)
+	if err != nil {
 		return fmt.Errorf("Delete %s: %w", key, err)
 	}
+	if err := q.cs.deleteFile(ctx, key); err != nil {
+		return q.cfg.deleteError(err)
+	}
+	q.cfg.quotas.releaseObject(key, previous)
+	return nil
+}
+
+// DeleteIfGeneration only deletes the object if it is still at generation,
+// e.g. as returned by GetIfChanged. ErrGenerationMismatch is returned if it
+// changed, and ErrInvalidGeneration for generations below one.
+func (q *querier[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) (err error) {
+	defer q.cs.finish("DeleteIfGeneration", key, &err)
+
+	if generation <= 0 {
+		return fmt.Errorf("DeleteIfGeneration %s: %w %d", key, ErrInvalidGeneration, generation)
+	}
+	defer q.locks.lock(key)()
+	q.cache.evict(key)
+	q.hints.evict(key)
+	previous, err := q.cfg.quotas.previous(ctx, key)
+	if err != nil {
+		return fmt.Errorf("DeleteIfGeneration %s: %w", key, err)
+	}
+	err = q.cs.deleteFileIf(ctx, key, storage.Conditions{GenerationMatch: generation})
+	if isPreconditionFailed(err) {
+		return &storageError{cause: err, mask: ErrGenerationMismatch}
+	} else if err != nil {
+		return err
+	}
+	if previous != nil && previous.Generation == generation {
+		q.cfg.quotas.releaseObject(key, previous)
+	}
 	return nil
 }
 
 func wrapStorageError(err error) error {
+	var gerr *googleapi.Error
 	if errors.Is(err, storage.ErrObjectNotExist) {
 		return &storageError{cause: err, mask: ErrObjectNotFound}
+	} else if errors.As(err, &gerr) && gerr.Code == http.StatusNotModified {
+		return &storageError{cause: err, mask: ErrNotModified}
+	}
+	return err
+}
+
+// wrapWriteError masks the error of a write like wrapStorageError, and
+// failed preconditions with ErrObjectExists or ErrGenerationMismatch
+// depending on the condition of the write. Metageneration conditions count
+// as generation conditions.
+func wrapWriteError(err error, conds storage.Conditions) error {
+	switch {
+	case !isPreconditionFailed(err):
+		return wrapStorageError(err)
+	case conds.DoesNotExist:
+		return &storageError{cause: err, mask: ErrObjectExists}
+	case conds.GenerationMatch != 0, conds.MetagenerationMatch != 0:
+		return &storageError{cause: err, mask: ErrGenerationMismatch}
 	}
 	return err
 }
@@ -122,6 +646,12 @@ func (s *storageError) Unwrap() error {
 func (s *storageError) Is(e error) bool {
 	return s.mask == e || s.cause == e
 }
+
+// As finds the details of the cause, e.g. the googleapi.Error of a masked
+// precondition failure.
+func (s *storageError) As(target any) bool {
+	return errors.As(s.cause, target)
+}
 func (s *storageError) Error() string {
 	return fmt.Sprintf("%s: %s", s.mask.Error(), s.cause.Error())
 }
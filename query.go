@@ -0,0 +1,332 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Direction is the sort order of Query.OrderBy.
+type Direction int
+
+const (
+	Asc Direction = iota
+	Desc
+)
+
+// errQueryDone stops the scan of a query once enough results are found.
+var errQueryDone = errors.New("query done")
+
+// ErrNotIndexed is returned by IndexedStore.QueryIndexed for lookups the
+// index can't serve, making Query scan the prefix instead.
+var ErrNotIndexed = errors.New("not indexed")
+
+// IndexedStore is a CRUDStore which can look up objects by the values of
+// indexed fields, such as fsindex.Store. Fields are named and values compared
+// like Query.Filter, with op one of its operators except "!=".
+type IndexedStore[T any] interface {
+	CRUDStore[T]
+	QueryIndexed(ctx context.Context, path, op string, value interface{}) ([]Entry[T], error)
+}
+
+// Query selects objects under a prefix by the values of their JSON fields,
+// e.g.
+//
+//	active, err := NewQuery(store, "users/").
+//		Filter("status", "=", "active").
+//		OrderBy("createdAt", Desc).
+//		Limit(50).
+//		Run(ctx)
+//
+// Queries of an IndexedStore look up the objects matching the first filter
+// in the index, unless it is "!=". Other queries are executed as a parallel
+// scan of the prefix with ForEach, so every object under the prefix is
+// downloaded. Fields are named by their JSON names, with dots separating
+// nested fields, e.g. `address.city`.
+type Query[T any] struct {
+	store   CRUDStore[T]
+	prefix  string
+	filters []queryFilter
+	orders  []queryOrder
+	limit   int
+	workers int
+	err     error
+}
+
+type queryFilter struct {
+	field string
+	op    string
+	value interface{}
+	raw   interface{}
+}
+
+type queryOrder struct {
+	field string
+	dir   Direction
+}
+
+// NewQuery creates a query of the objects of store under prefix.
+func NewQuery[T any](store CRUDStore[T], prefix string) *Query[T] {
+	return &Query[T]{store: store, prefix: prefix, workers: reduceWorkers}
+}
+
+// Filter only selects objects whose field compares to value with op, one of
+// "=", "!=", "<", "<=", ">" and ">=". Values are compared in their JSON
+// representation, so numbers compare numerically and strings, including
+// times, lexicographically. Fields of another type than value only match
+// "!=". Multiple filters must all match.
+func (q *Query[T]) Filter(field, op string, value interface{}) *Query[T] {
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		q.fail(fmt.Errorf("unknown operator %q", op))
+		return q
+	}
+	normalized, err := normalizeJSON(value)
+	if err != nil {
+		q.fail(fmt.Errorf("filter %s: %w", field, err))
+		return q
+	}
+	q.filters = append(q.filters, queryFilter{field, op, normalized, value})
+	return q
+}
+
+// OrderBy sorts the results by field, then by the fields of later calls.
+// Results are sorted by key otherwise.
+func (q *Query[T]) OrderBy(field string, dir Direction) *Query[T] {
+	q.orders = append(q.orders, queryOrder{field, dir})
+	return q
+}
+
+// Limit returns at most n results. Without OrderBy the scan stops as soon as
+// n objects match, so which ones are returned is arbitrary.
+func (q *Query[T]) Limit(n int) *Query[T] {
+	q.limit = n
+	return q
+}
+
+// Workers sets the number of objects downloaded concurrently.
+// Defaults to 8
+func (q *Query[T]) Workers(n int) *Query[T] {
+	q.workers = n
+	return q
+}
+
+func (q *Query[T]) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// Run executes the query. The entries only have Key and Value set.
+func (q *Query[T]) Run(ctx context.Context) ([]Entry[T], error) {
+	if q.err != nil {
+		return nil, fmt.Errorf("Query %s: %w", q.prefix, q.err)
+	}
+
+	var (
+		mu      sync.Mutex
+		results []queryResult[T]
+	)
+	collect := func(key string, obj *T) error {
+		doc, err := normalizeJSON(obj)
+		if err != nil {
+			return err
+		}
+		if !q.matches(doc) {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, queryResult[T]{key, obj, doc})
+		if q.limit > 0 && len(q.orders) == 0 && len(results) >= q.limit {
+			return errQueryDone
+		}
+		// only keep the best results around when sorting
+		if q.limit > 0 && len(results) >= 2*q.limit {
+			q.sort(results)
+			results = results[:q.limit]
+		}
+		return nil
+	}
+
+	err := q.lookup(ctx, collect)
+	if errors.Is(err, ErrNotIndexed) {
+		err = q.store.ForEach(ctx, q.prefix, q.workers, collect)
+	}
+	if err != nil && !errors.Is(err, errQueryDone) {
+		return nil, fmt.Errorf("Query %s: %w", q.prefix, err)
+	}
+
+	q.sort(results)
+	if q.limit > 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	entries := make([]Entry[T], len(results))
+	for i, r := range results {
+		entries[i] = Entry[T]{Key: r.key, Value: r.obj}
+	}
+	return entries, nil
+}
+
+// lookup calls fn with the objects under the prefix matching the first filter
+// according to the index of the store, ErrNotIndexed if it can't be used.
+// The index may be stale, so fn still checks every filter.
+func (q *Query[T]) lookup(ctx context.Context, fn func(string, *T) error) error {
+	indexed, ok := q.store.(IndexedStore[T])
+	if !ok || len(q.filters) == 0 || q.filters[0].op == "!=" {
+		return ErrNotIndexed
+	}
+	f := q.filters[0]
+	entries, err := indexed.QueryIndexed(ctx, f.field, f.op, f.raw)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Key, q.prefix) {
+			continue
+		}
+		if err := fn(e.Key, e.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type queryResult[T any] struct {
+	key string
+	obj *T
+	doc interface{}
+}
+
+func (q *Query[T]) matches(doc interface{}) bool {
+	for _, f := range q.filters {
+		c, ok := compareJSON(lookupField(doc, f.field), f.value)
+		var match bool
+		switch f.op {
+		case "=":
+			match = ok && c == 0
+		case "!=":
+			match = !ok || c != 0
+		case "<":
+			match = ok && c < 0
+		case "<=":
+			match = ok && c <= 0
+		case ">":
+			match = ok && c > 0
+		case ">=":
+			match = ok && c >= 0
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *Query[T]) sort(results []queryResult[T]) {
+	sort.SliceStable(results, func(i, j int) bool {
+		for _, o := range q.orders {
+			c := orderJSON(lookupField(results[i].doc, o.field), lookupField(results[j].doc, o.field))
+			if o.dir == Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return results[i].key < results[j].key
+	})
+}
+
+// normalizeJSON converts v to its generic JSON representation, keeping
+// numbers as json.Number.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// lookupField returns the value of the dot separated field in doc, nil if it
+// doesn't exist.
+func lookupField(doc interface{}, field string) interface{} {
+	for _, name := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[name]
+	}
+	return doc
+}
+
+// compareJSON compares two JSON values of the same type. ok is false for
+// values of different types and for ordering objects and arrays.
+func compareJSON(a, b interface{}) (c int, ok bool) {
+	switch a := a.(type) {
+	case nil:
+		return 0, b == nil
+	case bool:
+		if b, isBool := b.(bool); isBool {
+			if a == b {
+				return 0, true
+			} else if !a {
+				return -1, true
+			}
+			return 1, true
+		}
+	case json.Number:
+		if b, isNumber := b.(json.Number); isNumber {
+			// 64 bits of mantissa hold every int64 and float64 exactly
+			x, _, err1 := big.ParseFloat(string(a), 10, 64, big.ToNearestEven)
+			y, _, err2 := big.ParseFloat(string(b), 10, 64, big.ToNearestEven)
+			if err1 != nil || err2 != nil {
+				return 0, false
+			}
+			return x.Cmp(y), true
+		}
+	case string:
+		if b, isString := b.(string); isString {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// orderJSON orders any two JSON values, values of different types by type:
+// null, booleans, numbers, strings, then everything else.
+func orderJSON(a, b interface{}) int {
+	if c, ok := compareJSON(a, b); ok {
+		return c
+	}
+	return jsonTypeRank(a) - jsonTypeRank(b)
+}
+
+func jsonTypeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case json.Number:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
@@ -0,0 +1,188 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	// ErrQueueEmpty is returned by Lease if no item is available.
+	ErrQueueEmpty = errors.New("queue is empty")
+	// ErrLeaseLost is returned by Ack and Nack if the lease expired and the
+	// item was leased again or removed.
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+const (
+	metaLeasedUntil = "objectstore-leased-until"
+	metaAttempts    = "objectstore-attempts"
+)
+
+// Lease is a claimed queue item.
+type Lease[T any] struct {
+	ID          string
+	Value       T
+	Attempts    int
+	LeasedUntil time.Time
+
+	generation     int64
+	metageneration int64
+}
+
+// Queue is a simple work queue of objects under a prefix, good enough for
+// low-throughput background jobs. Leases are recorded in the object metadata
+// and claimed with metageneration preconditions, so payloads are never
+// rewritten and each lease is held by one consumer at a time.
+type Queue[T any] struct {
+	cs     *CloudStorage
+	items  *querier[T]
+	prefix string
+}
+
+// NewQueue creates a Queue of the items under prefix, e.g. `jobs/emails/`.
+func NewQueue[T any](cs *CloudStorage, prefix string) *Queue[T] {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Queue[T]{cs: cs, items: newQuerier[T](cs), prefix: prefix}
+}
+
+// Enqueue adds item to the queue and returns its ID. Items are leased in
+// roughly the order they were enqueued.
+func (q *Queue[T]) Enqueue(ctx context.Context, item T) (string, error) {
+	id, err := sequentialID()
+	if err != nil {
+		return "", fmt.Errorf("Enqueue: %w", err)
+	}
+
+	if err := q.items.Create(ctx, q.prefix+id, item); err != nil {
+		return "", fmt.Errorf("Enqueue: %w", err)
+	}
+	return id, nil
+}
+
+// sequentialID returns a unique ID sorting after the IDs created before.
+func sequentialID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%020d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix)), nil
+}
+
+// Lease claims the oldest available item for visibility. Unless it's acked
+// before the lease expires, the item becomes available again.
+// ErrQueueEmpty is returned if no item is available.
+func (q *Queue[T]) Lease(ctx context.Context, visibility time.Duration) (*Lease[T], error) {
+	it := q.cs.bucket.Objects(ctx, &storage.Query{
+		Prefix:     q.cs.FilenamePrefix(q.prefix),
+		Projection: storage.ProjectionNoACL,
+	})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil, ErrQueueEmpty
+		} else if err != nil {
+			return nil, fmt.Errorf("Lease: %w", err)
+		}
+
+		key, ok := q.cs.Key(attrs.Name)
+		if !ok || !strings.HasPrefix(key, q.prefix) {
+			continue
+		}
+		if until, _ := time.Parse(time.RFC3339Nano, attrs.Metadata[metaLeasedUntil]); time.Now().Before(until) {
+			continue
+		}
+
+		lease, err := q.claim(ctx, key, attrs, visibility)
+		if errors.Is(err, ErrLeaseLost) || errors.Is(err, ErrObjectNotFound) {
+			continue // claimed or acked by someone else
+		} else if err != nil {
+			return nil, fmt.Errorf("Lease %s: %w", key, err)
+		}
+		return lease, nil
+	}
+}
+
+func (q *Queue[T]) claim(ctx context.Context, key string, attrs *storage.ObjectAttrs, visibility time.Duration) (*Lease[T], error) {
+	attempts, _ := strconv.Atoi(attrs.Metadata[metaAttempts])
+	until := time.Now().Add(visibility)
+
+	// metadata updates are merged with the existing keys
+	metadata := map[string]string{
+		metaLeasedUntil: until.UTC().Format(time.RFC3339Nano),
+		metaAttempts:    strconv.Itoa(attempts + 1),
+	}
+
+	o := q.cs.bucket.Object(attrs.Name).If(storage.Conditions{
+		GenerationMatch:     attrs.Generation,
+		MetagenerationMatch: attrs.Metageneration,
+	})
+	updated, err := o.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return nil, wrapLeaseError(err)
+	}
+
+	reader, err := q.cs.newReader(ctx, q.cs.readObject(attrs.Name).Generation(updated.Generation))
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, err2
+	}
+	defer reader.Close()
+
+	lease := &Lease[T]{
+		ID:             strings.TrimPrefix(key, q.prefix),
+		Attempts:       attempts + 1,
+		LeasedUntil:    until,
+		generation:     updated.Generation,
+		metageneration: updated.Metageneration,
+	}
+	if err := json.NewDecoder(reader).Decode(&lease.Value); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Ack removes the leased item from the queue.
+func (q *Queue[T]) Ack(ctx context.Context, lease *Lease[T]) error {
+	err := q.leased(lease).Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("Ack %s: %w", lease.ID, wrapLeaseError(err))
+	}
+	return nil
+}
+
+// Nack releases the lease, making the item available again right away.
+func (q *Queue[T]) Nack(ctx context.Context, lease *Lease[T]) error {
+	// an empty lease parses as the zero time which has long expired
+	metadata := map[string]string{metaLeasedUntil: ""}
+	if _, err := q.leased(lease).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata}); err != nil {
+		return fmt.Errorf("Nack %s: %w", lease.ID, wrapLeaseError(err))
+	}
+	return nil
+}
+
+// leased returns the object handle guarded by the lease.
+func (q *Queue[T]) leased(lease *Lease[T]) *storage.ObjectHandle {
+	return q.cs.bucket.Object(q.cs.Filename(q.prefix + lease.ID)).If(storage.Conditions{
+		GenerationMatch:     lease.generation,
+		MetagenerationMatch: lease.metageneration,
+	})
+}
+
+// wrapLeaseError masks failed preconditions with ErrLeaseLost.
+func wrapLeaseError(err error) error {
+	if isPreconditionFailed(err) {
+		return &storageError{cause: err, mask: ErrLeaseLost}
+	}
+	return wrapStorageError(err)
+}
@@ -0,0 +1,224 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ErrQuotaExceeded is returned by writes which would take the usage of a
+// prefix beyond its quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota is a number of objects and bytes, either the limit or the usage of a
+// prefix. Zero limits are unlimited.
+type Quota struct {
+	Objects int64
+	Bytes   int64
+}
+
+// Quotas tracks the usage of key prefixes, e.g. one per tenant, and rejects
+// writes exceeding their limits. Usage is maintained in memory by the writes
+// of the stores using it, see WithQuotas, and corrected by Reconcile.
+//
+// Writes and deletes read the attributes of the object they replace to
+// account the difference, so usage only drifts from concurrent writes of the
+// same key and writes by other processes.
+type Quotas struct {
+	cs     *CloudStorage
+	limits map[string]Quota
+
+	mu    sync.Mutex
+	usage map[string]Quota
+}
+
+// NewQuotas creates Quotas limiting the usage of the keys under each prefix.
+// Usage starts at zero, call Reconcile to load it from the bucket.
+func NewQuotas(cs *CloudStorage, limits map[string]Quota) *Quotas {
+	return &Quotas{cs: cs, limits: limits, usage: make(map[string]Quota)}
+}
+
+// Usage returns the tracked usage of prefix.
+func (qs *Quotas) Usage(prefix string) Quota {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.usage[prefix]
+}
+
+// Reconcile scans the objects under every prefix and replaces the tracked
+// usage with the actual one. Run it at startup and periodically to correct
+// drift from overwrites, deletes and writes by other processes.
+func (qs *Quotas) Reconcile(ctx context.Context) error {
+	for prefix := range qs.limits {
+		usage, err := qs.scan(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("Reconcile %s: %w", prefix, err)
+		}
+		qs.mu.Lock()
+		qs.usage[prefix] = usage
+		qs.mu.Unlock()
+	}
+	return nil
+}
+
+// scan sums up the objects with keys under prefix, the same objects whose
+// writes reserve usage of prefix.
+func (qs *Quotas) scan(ctx context.Context, prefix string) (Quota, error) {
+	var usage Quota
+	it := qs.cs.list(ctx, prefix, "Name", "Size")
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return usage, nil
+		} else if err != nil {
+			return usage, err
+		}
+		if _, ok := qs.cs.Key(attrs.Name); ok {
+			usage.Objects++
+			usage.Bytes += attrs.Size
+		}
+	}
+}
+
+// reserve adds delta to the usage of all prefixes of key, failing with
+// ErrQuotaExceeded without changing anything if any limit would be exceeded.
+func (qs *Quotas) reserve(key string, delta Quota) error {
+	if qs == nil {
+		return nil
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	for prefix, limit := range qs.limits {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		usage := qs.usage[prefix]
+		if limit.Objects > 0 && usage.Objects+delta.Objects > limit.Objects {
+			return fmt.Errorf("%w: %s has %d of %d objects", ErrQuotaExceeded, prefix, usage.Objects, limit.Objects)
+		}
+		if limit.Bytes > 0 && usage.Bytes+delta.Bytes > limit.Bytes {
+			return fmt.Errorf("%w: %s has %d of %d bytes", ErrQuotaExceeded, prefix, usage.Bytes, limit.Bytes)
+		}
+	}
+	for prefix := range qs.limits {
+		if strings.HasPrefix(key, prefix) {
+			usage := qs.usage[prefix]
+			qs.usage[prefix] = Quota{Objects: usage.Objects + delta.Objects, Bytes: usage.Bytes + delta.Bytes}
+		}
+	}
+	return nil
+}
+
+// release subtracts delta from the usage of all prefixes of key.
+func (qs *Quotas) release(key string, delta Quota) {
+	if qs == nil {
+		return
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	for prefix := range qs.limits {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		usage := qs.usage[prefix]
+		usage.Objects = max64(usage.Objects-delta.Objects, 0)
+		usage.Bytes = max64(usage.Bytes-delta.Bytes, 0)
+		qs.usage[prefix] = usage
+	}
+}
+
+// previous returns the attributes of the object at key which a write or
+// delete is about to replace, for accounting the difference. It is nil if the
+// object doesn't exist, and without reading anything if qs is nil.
+func (qs *Quotas) previous(ctx context.Context, key string) (*storage.ObjectAttrs, error) {
+	if qs == nil {
+		return nil, nil
+	}
+	attrs, err := qs.cs.bucket.Object(qs.cs.Filename(key)).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// reserveWrite reserves the write of the payload read from r to key,
+// replacing previous, nil for new objects. Overwrites only reserve the
+// difference in size. Payloads of unknown size are reserved as they are read,
+// failing the read with ErrQuotaExceeded, which aborts the upload reading from
+// it. The returned func settles the reservation with the result of the write.
+func (qs *Quotas) reserveWrite(key string, r io.Reader, previous *storage.ObjectAttrs) (io.Reader, func(error), error) {
+	if qs == nil {
+		return r, func(error) {}, nil
+	}
+	delta := Quota{Objects: 1}
+	if previous != nil {
+		delta = Quota{Bytes: -previous.Size}
+	}
+
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		delta.Bytes += s.Size()
+		if err := qs.reserve(key, delta); err != nil {
+			return nil, nil, err
+		}
+		return r, func(err error) {
+			if err != nil {
+				qs.release(key, delta)
+			}
+		}, nil
+	}
+
+	// the replaced bytes are only released once the write succeeded
+	if err := qs.reserve(key, Quota{Objects: delta.Objects}); err != nil {
+		return nil, nil, err
+	}
+	qr := &quotaReader{r: r, key: key, quotas: qs}
+	return qr, func(err error) {
+		if err != nil {
+			qs.release(key, Quota{Objects: delta.Objects, Bytes: qr.n})
+		} else {
+			qs.release(key, Quota{Bytes: -delta.Bytes})
+		}
+	}, nil
+}
+
+// releaseObject releases the object previous at key after it was deleted.
+func (qs *Quotas) releaseObject(key string, previous *storage.ObjectAttrs) {
+	if previous != nil {
+		qs.release(key, Quota{Objects: 1, Bytes: previous.Size})
+	}
+}
+
+type quotaReader struct {
+	r      io.Reader
+	key    string
+	quotas *Quotas
+	n      int64
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	if n > 0 {
+		if qerr := qr.quotas.reserve(qr.key, Quota{Bytes: int64(n)}); qerr != nil {
+			return n, qerr
+		}
+		qr.n += int64(n)
+	}
+	return n, err
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,51 @@
+// Package rediscache implements objectstore.Cache on Redis, letting the
+// Cached decorator share cached objects between processes.
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lingio/objectstore"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores values in Redis under a key prefix.
+type Cache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ objectstore.Cache = (*Cache)(nil)
+
+// New creates a Cache storing values in client. prefix is prepended to every
+// key, e.g. `objectstore:users:`, to keep several caches apart in one database.
+func New(client redis.UniversalClient, prefix string) *Cache {
+	return &Cache{client: client, prefix: prefix}
+}
+
+// Get
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		// go-redis would keep the TTL of the previous value
+		ttl = 0
+	}
+	return c.client.Set(ctx, c.prefix+key, value, ttl).Err()
+}
+
+// Delete
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, c.prefix+key).Err()
+}
@@ -0,0 +1,68 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// reduceWorkers is the number of objects Reduce fetches concurrently.
+const reduceWorkers = 8
+
+// sequencedStore is implemented by the stores whose ForEach can number the
+// objects in listing order, see querier.forEachSeq.
+type sequencedStore[T any] interface {
+	forEachSeq(ctx context.Context, prefix string, workers int, fn func(seq int, key string, obj *T) error) error
+}
+
+// Reduce folds every object under prefix into an accumulator starting at
+// init, without loading them all into memory first. Objects are folded in
+// listing order, so the result is deterministic even if fn depends on the
+// order. They are fetched and decoded in parallel and buffered until the
+// objects listed before them are folded. fn is never called concurrently, so
+// it needs no locking.
+//
+// Stores wrapped by decorators can't number their objects and are fetched one
+// at a time instead.
+func Reduce[T, A any](ctx context.Context, store CRUDStore[T], prefix string, init A, fn func(A, *T) A) (A, error) {
+	var mu sync.Mutex
+	acc := init
+	next := 0
+	pending := make(map[int]*T)
+	fold := func(seq int, _ string, obj *T) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pending[seq] = obj
+		for {
+			obj, ok := pending[next]
+			if !ok {
+				return nil
+			}
+			delete(pending, next)
+			next++
+			if obj != nil {
+				acc = fn(acc, obj)
+			}
+		}
+	}
+
+	var err error
+	if s, ok := store.(sequencedStore[T]); ok {
+		err = s.forEachSeq(ctx, prefix, reduceWorkers, fold)
+	} else {
+		// a single worker calls back in listing order
+		seq := 0
+		err = store.ForEach(ctx, prefix, 1, func(key string, obj *T) error {
+			seq++
+			return fold(seq-1, key, obj)
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		return acc, fmt.Errorf("Reduce %s: %w", prefix, err)
+	}
+	return acc, nil
+}
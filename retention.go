@@ -0,0 +1,68 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// RetentionPolicy returns the retention policy of the bucket, nil if it has none.
+func (cs *CloudStorage) RetentionPolicy(ctx context.Context) (*storage.RetentionPolicy, error) {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("RetentionPolicy: %w", err)
+	}
+	return attrs.RetentionPolicy, nil
+}
+
+// SetRetentionPeriod sets the minimum time objects in the bucket are retained.
+// A period of 0 removes the retention policy. Locked policies can only be
+// extended.
+func (cs *CloudStorage) SetRetentionPeriod(ctx context.Context, period time.Duration) error {
+	_, err := cs.bucket.Update(ctx, storage.BucketAttrsToUpdate{
+		RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: period},
+	})
+	if err != nil {
+		return fmt.Errorf("SetRetentionPeriod %s: %w", period, err)
+	}
+	return nil
+}
+
+// EnsureRetentionPeriod extends the retention period to at least period, e.g.
+// at service startup. Longer existing periods are left as is.
+func (cs *CloudStorage) EnsureRetentionPeriod(ctx context.Context, period time.Duration) error {
+	policy, err := cs.RetentionPolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("EnsureRetentionPeriod %s: %w", period, err)
+	}
+	if policy != nil && policy.RetentionPeriod >= period {
+		return nil
+	}
+	return cs.SetRetentionPeriod(ctx, period)
+}
+
+// LockRetentionPolicy permanently locks the current retention policy of the
+// bucket. This is irreversible: a locked policy can't be removed or shortened
+// and the bucket can't be deleted until all objects have met their retention.
+func (cs *CloudStorage) LockRetentionPolicy(ctx context.Context) error {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("LockRetentionPolicy: %w", err)
+	}
+	if attrs.RetentionPolicy == nil {
+		return fmt.Errorf("LockRetentionPolicy: bucket has no retention policy")
+	}
+	if attrs.RetentionPolicy.IsLocked {
+		return nil
+	}
+
+	// locking requires the metageneration so we lock the policy we just read
+	err = cs.bucket.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).
+		LockRetentionPolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("LockRetentionPolicy: %w", err)
+	}
+	return nil
+}
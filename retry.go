@@ -0,0 +1,69 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RetryBudget bounds how read-modify-write operations such as Patch and Swap
+// retry when the object changes between reading and writing it back.
+// Attempts are stopped by whichever limit is hit first, and an attempt is
+// skipped if it can't finish before the caller's context deadline or the
+// budget runs out, returning context.DeadlineExceeded right away.
+type RetryBudget struct {
+	// MaxAttempts caps the number of attempts. Values below one mean the
+	// default of 3 attempts.
+	MaxAttempts int
+
+	// Budget is the total time a call may spend on attempts. 0 means only
+	// the context deadline applies.
+	Budget time.Duration
+
+	// Backoff is the delay before the second attempt, doubled for every
+	// following attempt.
+	Backoff time.Duration
+}
+
+// defaultRetryBudget retries conflicts casAttempts times without delay.
+var defaultRetryBudget = RetryBudget{MaxAttempts: casAttempts}
+
+// run calls attempt until it succeeds, fails with an error retryable doesn't
+// accept or the budget is spent.
+func (b RetryBudget) run(ctx context.Context, attempt func(context.Context) error, retryable func(error) bool) error {
+	if b.MaxAttempts <= 0 {
+		b.MaxAttempts = casAttempts
+	}
+	if b.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Budget)
+		defer cancel()
+	}
+
+	backoff := b.Backoff
+	for n := 1; ; n++ {
+		start := time.Now()
+		err := attempt(ctx)
+		if err == nil || !retryable(err) || n == b.MaxAttempts {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w after %d attempts: %v", ctx.Err(), n, err)
+		}
+
+		// assume the next attempt takes as long as the last one
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff+time.Since(start)).After(deadline) {
+			return fmt.Errorf("%w after %d attempts: %v", context.DeadlineExceeded, n, err)
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("%w after %d attempts: %v", ctx.Err(), n, err)
+			}
+			backoff *= 2
+		}
+	}
+}
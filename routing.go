@@ -0,0 +1,32 @@
+package objectstore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// readObject returns the handle of the object name for downloads, which go
+// through the read endpoint if configured WithReadEndpoint.
+func (cs *CloudStorage) readObject(name string) *storage.ObjectHandle {
+	return cs.readbucket.Object(name)
+}
+
+// objectAttrs reads the attributes of the object called name like
+// readObject, holding an operation slot while doing so.
+func (cs *CloudStorage) objectAttrs(ctx context.Context, name string) (*storage.ObjectAttrs, error) {
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return cs.readObject(name).Attrs(ctx)
+}
+
+// measure reports the time since start to the latency observer, if any.
+func (cs *CloudStorage) measure(op string, start time.Time) {
+	if cs.latency != nil {
+		cs.latency(op, time.Since(start))
+	}
+}
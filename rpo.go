@@ -0,0 +1,36 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// RPO returns the recovery point objective of the bucket, RPOAsyncTurbo if
+// turbo replication is enabled. It is RPOUnknown for single-region buckets.
+func (cs *CloudStorage) RPO(ctx context.Context) (storage.RPO, error) {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return storage.RPOUnknown, fmt.Errorf("RPO: %w", err)
+	}
+	return attrs.RPO, nil
+}
+
+// SetRPO sets the recovery point objective of the bucket, e.g.
+// storage.RPOAsyncTurbo to enforce the replication SLA of dual-region buckets
+// from DR tooling. The bucket is left as is if it already has rpo.
+func (cs *CloudStorage) SetRPO(ctx context.Context, rpo storage.RPO) error {
+	attrs, err := cs.bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("SetRPO: %w", err)
+	}
+	if attrs.RPO == rpo {
+		return nil
+	}
+	_, err = cs.bucket.Update(ctx, storage.BucketAttrsToUpdate{RPO: rpo})
+	if err != nil {
+		return fmt.Errorf("SetRPO: %w", err)
+	}
+	return nil
+}
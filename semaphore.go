@@ -0,0 +1,18 @@
+package objectstore
+
+import "context"
+
+// acquire waits for a free operation slot when the CloudStorage was created
+// WithMaxConcurrentOps. The returned func frees the slot again and must be
+// called exactly once.
+func (cs *CloudStorage) acquire(ctx context.Context) (func(), error) {
+	if cs.ops == nil {
+		return func() {}, nil
+	}
+	select {
+	case cs.ops <- struct{}{}:
+		return func() { <-cs.ops }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
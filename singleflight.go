@@ -0,0 +1,53 @@
+package objectstore
+
+import "sync"
+
+// flightGroup deduplicates concurrent calls for the same key, similar to
+// golang.org/x/sync/singleflight. A nil *flightGroup calls fn directly.
+type flightGroup[T any] struct {
+	mu      sync.Mutex
+	flights map[string]*flight[T]
+}
+
+type flight[T any] struct {
+	wg  sync.WaitGroup
+	val *T
+	err error
+}
+
+func newFlightGroup[T any]() *flightGroup[T] {
+	return &flightGroup[T]{flights: make(map[string]*flight[T])}
+}
+
+// do calls fn once for all concurrent callers with the same key. Each caller
+// gets its own shallow copy of the result.
+func (g *flightGroup[T]) do(key string, fn func() (*T, error)) (*T, error) {
+	if g == nil {
+		return fn()
+	}
+
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		f = &flight[T]{}
+		f.wg.Add(1)
+		g.flights[key] = f
+	}
+	g.mu.Unlock()
+
+	if !ok {
+		f.val, f.err = fn()
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		f.wg.Done()
+	} else {
+		f.wg.Wait()
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	v := *f.val
+	return &v, nil
+}
@@ -0,0 +1,121 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// SliceOptions configures DownloadSliced.
+type SliceOptions struct {
+	// SliceSize is the number of bytes fetched per range request.
+	// Defaults to 64 MiB
+	SliceSize int64
+
+	// Concurrency is the number of slices fetched at the same time.
+	// Defaults to 8
+	Concurrency int
+}
+
+// DownloadSliced downloads the object at key into w by fetching byte ranges
+// concurrently, which is faster than a single stream for multi-GB objects.
+// All slices are read from the same generation, so the download fails rather
+// than mixing versions if the object is replaced meanwhile. Objects stored
+// with gzip content encoding are downloaded compressed, as stored.
+// The number of bytes written is returned.
+func (cs *CloudStorage) DownloadSliced(ctx context.Context, key string, w io.WriterAt, opts SliceOptions) (int64, error) {
+	if opts.SliceSize <= 0 {
+		opts.SliceSize = 64 << 20
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+
+	o := cs.readObject(cs.Filename(key))
+	attrs, err := o.Attrs(ctx)
+	if err2 := wrapStorageError(withDetails("DownloadSliced", key, err)); err2 != nil {
+		return 0, fmt.Errorf("DownloadSliced %s: %w", key, err2)
+	}
+	o = o.Generation(attrs.Generation).ReadCompressed(true)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64)
+	go func() {
+		defer close(offsets)
+		for off := int64(0); off < attrs.Size; off += opts.SliceSize {
+			select {
+			case offsets <- off:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := range offsets {
+				if err := cs.downloadSlice(ctx, o, w, off, opts.SliceSize); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("slice at %d: %w", off, err)
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, fmt.Errorf("DownloadSliced %s: %w", key, wrapStorageError(firstErr))
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("DownloadSliced %s: %w", key, err)
+	}
+	return attrs.Size, nil
+}
+
+// downloadSlice copies up to length bytes of o starting at off to the same
+// offset of w.
+func (cs *CloudStorage) downloadSlice(ctx context.Context, o *storage.ObjectHandle, w io.WriterAt, off, length int64) error {
+	release, err := cs.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reader, err := o.NewRangeReader(ctx, off, length)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(&offsetWriter{w: w, off: off}, contextReader{ctx, reader})
+	return err
+}
+
+// offsetWriter writes sequentially to w starting at off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,232 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// snapshotIDFormat keeps all nine fractional digits, so that IDs sort by time
+// and snapshots taken within a second don't collide.
+const snapshotIDFormat = "20060102T150405.000000000Z"
+
+// Snapshot describes a point-in-time copy of a prefix.
+type Snapshot struct {
+	ID      string           `json:"id"`
+	Created time.Time        `json:"created"`
+	Prefix  string           `json:"prefix"`
+	Objects []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is an object copied into a snapshot.
+type SnapshotObject struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	Size       int64  `json:"size"`
+}
+
+// Snapshotter copies a prefix into `<root><timestamp>/...` together with a
+// manifest, giving lightweight point-in-time recovery without bucket versioning.
+type Snapshotter struct {
+	cs     *CloudStorage
+	prefix string
+	root   string
+	retain int
+}
+
+// NewSnapshotter creates a Snapshotter for all objects under prefix. Snapshots
+// are stored under root, e.g. `snapshots/`, and only the retain newest
+// snapshots are kept when pruning. A retain <= 0 keeps all snapshots.
+func NewSnapshotter(cs *CloudStorage, prefix, root string, retain int) *Snapshotter {
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return &Snapshotter{cs: cs, prefix: prefix, root: root, retain: retain}
+}
+
+// Run takes a snapshot and prunes old ones every interval until ctx is done.
+// Failures are reported to the error observer of the CloudStorage, see
+// WithErrorObserver, and retried at the next interval.
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if _, err := s.Snapshot(ctx); err != nil && ctx.Err() == nil {
+			s.cs.observe("Snapshot", s.prefix, err)
+		}
+		if err := s.Prune(ctx); err != nil && ctx.Err() == nil {
+			s.cs.observe("Prune", s.prefix, err)
+		}
+	}
+}
+
+// Snapshot copies all objects under the prefix into a new snapshot.
+func (s *Snapshotter) Snapshot(ctx context.Context) (*Snapshot, error) {
+	now := time.Now().UTC()
+	snap := &Snapshot{
+		ID:      now.Format(snapshotIDFormat),
+		Created: now,
+		Prefix:  s.prefix,
+	}
+
+	it := s.cs.bucket.Objects(ctx, &storage.Query{
+		Prefix:     s.prefix,
+		Projection: storage.ProjectionNoACL,
+	})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Snapshot %s: %w", snap.ID, err)
+		}
+		if strings.HasPrefix(attrs.Name, s.root) {
+			continue // don't snapshot snapshots
+		}
+
+		src := s.cs.bucket.Object(attrs.Name).Generation(attrs.Generation)
+		dst := s.cs.bucket.Object(s.objectName(snap.ID, attrs.Name))
+		if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+			return nil, fmt.Errorf("Snapshot %s: copy %s: %w", snap.ID, attrs.Name, err)
+		}
+		snap.Objects = append(snap.Objects, SnapshotObject{
+			Name:       attrs.Name,
+			Generation: attrs.Generation,
+			Size:       attrs.Size,
+		})
+	}
+
+	// the manifest is written last so only complete snapshots are listed
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot %s: %w", snap.ID, err)
+	}
+	writer := s.cs.newWriter(ctx, s.cs.bucket.Object(s.manifestName(snap.ID)))
+	writer.ContentType = "application/json"
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("Snapshot %s: manifest: copy: %w", snap.ID, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("Snapshot %s: manifest: Close: %w", snap.ID, err)
+	}
+
+	return snap, nil
+}
+
+// ListSnapshots returns all complete snapshots, newest first.
+func (s *Snapshotter) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	it := s.cs.bucket.Objects(ctx, &storage.Query{
+		Prefix:    s.root,
+		Delimiter: "/",
+	})
+
+	var snaps []*Snapshot
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ListSnapshots: %w", err)
+		}
+		if attrs.Prefix == "" {
+			continue
+		}
+
+		id := path.Base(attrs.Prefix)
+		snap, err := s.manifest(ctx, id)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue // incomplete or being written
+		} else if err != nil {
+			return nil, fmt.Errorf("ListSnapshots: %w", err)
+		}
+		snaps = append(snaps, snap)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID > snaps[j].ID })
+	return snaps, nil
+}
+
+// RestoreSnapshot copies every object in the snapshot back to its original
+// name, overwriting the current objects. Objects created after the snapshot
+// was taken are left untouched.
+func (s *Snapshotter) RestoreSnapshot(ctx context.Context, id string) error {
+	snap, err := s.manifest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("RestoreSnapshot %s: %w", id, err)
+	}
+
+	for _, obj := range snap.Objects {
+		src := s.cs.bucket.Object(s.objectName(id, obj.Name))
+		dst := s.cs.bucket.Object(obj.Name)
+		if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+			return fmt.Errorf("RestoreSnapshot %s: copy %s: %w", id, obj.Name, err)
+		}
+	}
+	return nil
+}
+
+// Prune deletes all but the retain newest snapshots.
+func (s *Snapshotter) Prune(ctx context.Context) error {
+	if s.retain <= 0 {
+		return nil
+	}
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		return fmt.Errorf("Prune: %w", err)
+	}
+	if len(snaps) <= s.retain {
+		return nil
+	}
+
+	for _, snap := range snaps[s.retain:] {
+		// delete the manifest first so a partially deleted snapshot isn't listed
+		if err := s.cs.bucket.Object(s.manifestName(snap.ID)).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("Prune %s: %w", snap.ID, err)
+		}
+		for _, obj := range snap.Objects {
+			err := s.cs.bucket.Object(s.objectName(snap.ID, obj.Name)).Delete(ctx)
+			if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				return fmt.Errorf("Prune %s: %w", snap.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Snapshotter) manifest(ctx context.Context, id string) (*Snapshot, error) {
+	reader, err := s.cs.newReader(ctx, s.cs.readObject(s.manifestName(id)))
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, fmt.Errorf("manifest %s: %w", id, err2)
+	}
+	defer reader.Close()
+
+	var snap Snapshot
+	if err := json.NewDecoder(reader).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+func (s *Snapshotter) manifestName(id string) string {
+	return s.root + id + "/manifest.json"
+}
+
+func (s *Snapshotter) objectName(id, name string) string {
+	return s.root + id + "/objects/" + name
+}
@@ -0,0 +1,52 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Stats summarizes the objects under a prefix.
+type Stats struct {
+	Count int64
+	Bytes int64
+
+	// Oldest and Newest are the min and max updated timestamps.
+	// Both are zero if Count is zero.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Stats aggregates count, total size and min/max updated timestamps of all
+// objects under prefix. Objects are streamed, not buffered.
+func (cs *CloudStorage) Stats(ctx context.Context, prefix string) (Stats, error) {
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated"}); err != nil {
+		return Stats{}, fmt.Errorf("Stats %s: %w", prefix, err)
+	}
+
+	var stats Stats
+	it := cs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("Stats %s: %w", prefix, err)
+		}
+
+		stats.Count++
+		stats.Bytes += attrs.Size
+		if stats.Oldest.IsZero() || attrs.Updated.Before(stats.Oldest) {
+			stats.Oldest = attrs.Updated
+		}
+		if attrs.Updated.After(stats.Newest) {
+			stats.Newest = attrs.Updated
+		}
+	}
+	return stats, nil
+}
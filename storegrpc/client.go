@@ -0,0 +1,304 @@
+package storegrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lingio/objectstore"
+	"google.golang.org/grpc"
+)
+
+// Client is a typed client of a store served with Register. It has the
+// methods of objectstore.CRUDStore except the listings returning a
+// storage.ObjectIterator, which can't be served remotely; use ListKeys
+// instead.
+type Client[T any] struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient creates a client of the store served on conn.
+func NewClient[T any](conn grpc.ClientConnInterface) *Client[T] {
+	return &Client[T]{conn: conn}
+}
+
+func (c *Client[T]) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	err := c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.CallContentSubtype(CodecName))
+	return fromStatus(err)
+}
+
+// Create
+func (c *Client[T]) Create(ctx context.Context, key string, obj T) error {
+	data, err := json.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, "Create", &ObjectRequest{Key: key, Value: data}, &Empty{})
+}
+
+// Get
+func (c *Client[T]) Get(ctx context.Context, key string) (*T, error) {
+	obj, _, err := c.GetIfChanged(ctx, key, 0)
+	return obj, err
+}
+
+// GetIfChanged only transfers the object if its generation differs from the
+// known generation, returning ErrNotModified otherwise.
+func (c *Client[T]) GetIfChanged(ctx context.Context, key string, generation int64) (*T, int64, error) {
+	var resp ObjectResponse
+	if err := c.invoke(ctx, "Get", &KeyRequest{Key: key, Generation: Int64(generation)}, &resp); err != nil {
+		return nil, generation, err
+	}
+	obj, err := decode[T](resp.Value)
+	if err != nil {
+		return nil, generation, err
+	}
+	return obj, int64(resp.Generation), nil
+}
+
+// GetOrCreate returns the object at key, creating it with the value returned
+// by factory if it doesn't exist. The bool reports whether it was created.
+func (c *Client[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	obj, err := c.Get(ctx, key)
+	if err == nil {
+		return obj, false, nil
+	} else if !errors.Is(err, objectstore.ErrObjectNotFound) {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+	created, err := factory()
+	if err != nil {
+		return nil, false, fmt.Errorf("GetOrCreate %s: factory: %w", key, err)
+	}
+	err = c.Create(ctx, key, created)
+	if err == nil {
+		return &created, true, nil
+	} else if !errors.Is(err, objectstore.ErrObjectExists) {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+	// someone else created it in the meantime
+	if obj, err = c.Get(ctx, key); err != nil {
+		return nil, false, fmt.Errorf("GetOrCreate %s: %w", key, err)
+	}
+	return obj, false, nil
+}
+
+// GetEntry returns the object at key together with its attributes.
+func (c *Client[T]) GetEntry(ctx context.Context, key string) (*objectstore.Entry[T], error) {
+	var resp EntryResponse
+	if err := c.invoke(ctx, "GetEntry", &KeyRequest{Key: key}, &resp); err != nil {
+		return nil, err
+	}
+	return decodeEntry[T](resp)
+}
+
+// GetAsOf returns the generation of the object that was live at t.
+func (c *Client[T]) GetAsOf(ctx context.Context, key string, t time.Time) (*T, error) {
+	var resp ObjectResponse
+	if err := c.invoke(ctx, "GetAsOf", &AsOfRequest{Key: key, Time: t}, &resp); err != nil {
+		return nil, err
+	}
+	return decode[T](resp.Value)
+}
+
+// GetField returns the field of the object at key addressed by the RFC 6901
+// JSON pointer.
+func (c *Client[T]) GetField(ctx context.Context, key, pointer string) (json.RawMessage, error) {
+	var resp ObjectResponse
+	if err := c.invoke(ctx, "GetField", &FieldRequest{Key: key, Pointer: pointer}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// Put
+func (c *Client[T]) Put(ctx context.Context, key string, obj T) error {
+	data, err := json.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, "Put", &ObjectRequest{Key: key, Value: data}, &Empty{})
+}
+
+// Set writes obj to key whether or not it exists.
+func (c *Client[T]) Set(ctx context.Context, key string, obj T) error {
+	data, err := json.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, "Set", &ObjectRequest{Key: key, Value: data}, &Empty{})
+}
+
+// Patch applies the RFC 7386 JSON merge patch to the object at key and
+// returns the result.
+func (c *Client[T]) Patch(ctx context.Context, key string, patch json.RawMessage) (*T, error) {
+	var resp ObjectResponse
+	if err := c.invoke(ctx, "Patch", &ObjectRequest{Key: key, Value: patch}, &resp); err != nil {
+		return nil, err
+	}
+	return decode[T](resp.Value)
+}
+
+// Swap writes obj to key and returns the value it replaced, or nil if the
+// object didn't exist.
+func (c *Client[T]) Swap(ctx context.Context, key string, obj T) (*T, error) {
+	data, err := json.Marshal(&obj)
+	if err != nil {
+		return nil, err
+	}
+	var resp ObjectResponse
+	if err := c.invoke(ctx, "Swap", &ObjectRequest{Key: key, Value: data}, &resp); err != nil {
+		return nil, err
+	} else if resp.Value == nil {
+		return nil, nil
+	}
+	return decode[T](resp.Value)
+}
+
+// Delete
+func (c *Client[T]) Delete(ctx context.Context, key string) error {
+	return c.invoke(ctx, "Delete", &KeyRequest{Key: key}, &Empty{})
+}
+
+// DeleteIfGeneration only deletes the object if it is still at generation.
+// Generations below one are rejected with ErrInvalidGeneration without
+// asking the server.
+func (c *Client[T]) DeleteIfGeneration(ctx context.Context, key string, generation int64) error {
+	if generation <= 0 {
+		return fmt.Errorf("DeleteIfGeneration %s: %w %d", key, objectstore.ErrInvalidGeneration, generation)
+	}
+	return c.invoke(ctx, "DeleteIfGeneration", &KeyRequest{Key: key, Generation: Int64(generation)}, &Empty{})
+}
+
+// ListKeys returns the keys of the objects under the key prefix.
+func (c *Client[T]) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	var resp ListResponse
+	if err := c.invoke(ctx, "List", &ListRequest{Prefix: prefix}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+// GetAll returns every object under prefix. progress may be nil, it is
+// reported once all entries have been received.
+func (c *Client[T]) GetAll(ctx context.Context, prefix string, progress objectstore.ProgressFunc) ([]objectstore.Entry[T], error) {
+	var resp EntriesResponse
+	if err := c.invoke(ctx, "GetAll", &ListRequest{Prefix: prefix}, &resp); err != nil {
+		return nil, err
+	}
+	entries := make([]objectstore.Entry[T], len(resp.Entries))
+	for i, e := range resp.Entries {
+		entry, err := decodeEntry[T](e)
+		if err != nil {
+			return nil, fmt.Errorf("GetAll %s: %w", prefix, err)
+		}
+		entries[i] = *entry
+		if progress != nil {
+			progress(i+1, len(resp.Entries), e.Key)
+		}
+	}
+	return entries, nil
+}
+
+// ForEach calls fn with every object under prefix, fetching them with workers
+// goroutines. Objects deleted while it is running are skipped, and the first
+// error cancels the remaining work.
+func (c *Client[T]) ForEach(ctx context.Context, prefix string, workers int, fn func(key string, obj *T) error) error {
+	keys, err := c.ListKeys(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("ForEach %s: %w", prefix, err)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var failure error
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				obj, err := c.Get(ctx, key)
+				if errors.Is(err, objectstore.ErrObjectNotFound) {
+					continue
+				} else if err == nil {
+					err = fn(key, obj)
+				}
+				if err != nil {
+					once.Do(func() {
+						failure = fmt.Errorf("ForEach %s: %s: %w", prefix, key, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+send:
+	for _, key := range keys {
+		select {
+		case queue <- key:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
+
+// DeleteAll deletes every object under prefix. progress may be nil.
+func (c *Client[T]) DeleteAll(ctx context.Context, prefix string, progress objectstore.ProgressFunc) error {
+	keys, err := c.ListKeys(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("DeleteAll %s: %w", prefix, err)
+	}
+	for i, key := range keys {
+		if err := c.Delete(ctx, key); err != nil && !errors.Is(err, objectstore.ErrObjectNotFound) {
+			return fmt.Errorf("DeleteAll %s: %w", prefix, err)
+		}
+		if progress != nil {
+			progress(i+1, len(keys), key)
+		}
+	}
+	return nil
+}
+
+// Prefetch warms the cache of the served store with the objects under
+// prefix.
+func (c *Client[T]) Prefetch(ctx context.Context, prefix string) error {
+	return c.invoke(ctx, "Prefetch", &ListRequest{Prefix: prefix}, &Empty{})
+}
+
+func decode[T any](data json.RawMessage) (*T, error) {
+	var obj T
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func decodeEntry[T any](resp EntryResponse) (*objectstore.Entry[T], error) {
+	obj, err := decode[T](resp.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &objectstore.Entry[T]{
+		Key:        resp.Key,
+		Value:      obj,
+		Created:    resp.Created,
+		Updated:    resp.Updated,
+		Generation: int64(resp.Generation),
+		Size:       int64(resp.Size),
+	}, nil
+}
@@ -0,0 +1,235 @@
+package storegrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc"
+)
+
+// storeServer is the interface of the service implementation.
+type storeServer interface {
+	Create(context.Context, *ObjectRequest) (*Empty, error)
+	Get(context.Context, *KeyRequest) (*ObjectResponse, error)
+	GetEntry(context.Context, *KeyRequest) (*EntryResponse, error)
+	GetAsOf(context.Context, *AsOfRequest) (*ObjectResponse, error)
+	GetField(context.Context, *FieldRequest) (*ObjectResponse, error)
+	Put(context.Context, *ObjectRequest) (*Empty, error)
+	Set(context.Context, *ObjectRequest) (*Empty, error)
+	Patch(context.Context, *ObjectRequest) (*ObjectResponse, error)
+	Swap(context.Context, *ObjectRequest) (*ObjectResponse, error)
+	Delete(context.Context, *KeyRequest) (*Empty, error)
+	DeleteIfGeneration(context.Context, *KeyRequest) (*Empty, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	GetAll(context.Context, *ListRequest) (*EntriesResponse, error)
+	Prefetch(context.Context, *ListRequest) (*Empty, error)
+}
+
+// Register serves store on s. key maps the object names of listings to keys,
+// typically the Key method of the CloudStorage of the store.
+func Register[T any](s grpc.ServiceRegistrar, store objectstore.CRUDStore[T], key func(name string) (string, bool)) {
+	s.RegisterService(&serviceDesc, &server[T]{store: store, key: key})
+}
+
+// server implements the service over a CRUDStore.
+type server[T any] struct {
+	store objectstore.CRUDStore[T]
+	key   func(string) (string, bool)
+}
+
+func (s *server[T]) Create(ctx context.Context, req *ObjectRequest) (*Empty, error) {
+	var obj T
+	if err := json.Unmarshal(req.Value, &obj); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, toStatus(s.store.Create(ctx, req.Key, obj))
+}
+
+// Get returns the object unless it is still at the known generation of the
+// request.
+func (s *server[T]) Get(ctx context.Context, req *KeyRequest) (*ObjectResponse, error) {
+	obj, generation, err := s.store.GetIfChanged(ctx, req.Key, int64(req.Generation))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return respond(obj, generation)
+}
+
+func (s *server[T]) GetEntry(ctx context.Context, req *KeyRequest) (*EntryResponse, error) {
+	entry, err := s.store.GetEntry(ctx, req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return respondEntry(*entry)
+}
+
+func (s *server[T]) GetAsOf(ctx context.Context, req *AsOfRequest) (*ObjectResponse, error) {
+	obj, err := s.store.GetAsOf(ctx, req.Key, req.Time)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return respond(obj, 0)
+}
+
+func (s *server[T]) GetField(ctx context.Context, req *FieldRequest) (*ObjectResponse, error) {
+	field, err := s.store.GetField(ctx, req.Key, req.Pointer)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ObjectResponse{Value: field}, nil
+}
+
+func (s *server[T]) Put(ctx context.Context, req *ObjectRequest) (*Empty, error) {
+	var obj T
+	if err := json.Unmarshal(req.Value, &obj); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, toStatus(s.store.Put(ctx, req.Key, obj))
+}
+
+func (s *server[T]) Set(ctx context.Context, req *ObjectRequest) (*Empty, error) {
+	var obj T
+	if err := json.Unmarshal(req.Value, &obj); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, toStatus(s.store.Set(ctx, req.Key, obj))
+}
+
+func (s *server[T]) Patch(ctx context.Context, req *ObjectRequest) (*ObjectResponse, error) {
+	obj, err := s.store.Patch(ctx, req.Key, req.Value)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return respond(obj, 0)
+}
+
+// Swap responds without a value if the object didn't exist.
+func (s *server[T]) Swap(ctx context.Context, req *ObjectRequest) (*ObjectResponse, error) {
+	var obj T
+	if err := json.Unmarshal(req.Value, &obj); err != nil {
+		return nil, toStatus(err)
+	}
+	previous, err := s.store.Swap(ctx, req.Key, obj)
+	if err != nil {
+		return nil, toStatus(err)
+	} else if previous == nil {
+		return &ObjectResponse{}, nil
+	}
+	return respond(previous, 0)
+}
+
+// Delete deletes the object unconditionally, ignoring the generation of the
+// request.
+func (s *server[T]) Delete(ctx context.Context, req *KeyRequest) (*Empty, error) {
+	return &Empty{}, toStatus(s.store.Delete(ctx, req.Key))
+}
+
+// DeleteIfGeneration only deletes the object at the generation of the
+// request, which must be set.
+func (s *server[T]) DeleteIfGeneration(ctx context.Context, req *KeyRequest) (*Empty, error) {
+	return &Empty{}, toStatus(s.store.DeleteIfGeneration(ctx, req.Key, int64(req.Generation)))
+}
+
+func (s *server[T]) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	resp := &ListResponse{Keys: []string{}}
+	it := s.store.List(ctx, req.Prefix)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return resp, nil
+		} else if err != nil {
+			return nil, toStatus(err)
+		}
+		if key, ok := s.key(attrs.Name); ok {
+			resp.Keys = append(resp.Keys, key)
+		}
+	}
+}
+
+func (s *server[T]) GetAll(ctx context.Context, req *ListRequest) (*EntriesResponse, error) {
+	entries, err := s.store.GetAll(ctx, req.Prefix, nil)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &EntriesResponse{Entries: make([]EntryResponse, len(entries))}
+	for i, entry := range entries {
+		e, err := respondEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		resp.Entries[i] = *e
+	}
+	return resp, nil
+}
+
+func (s *server[T]) Prefetch(ctx context.Context, req *ListRequest) (*Empty, error) {
+	return &Empty{}, toStatus(s.store.Prefetch(ctx, req.Prefix))
+}
+
+func respondEntry[T any](entry objectstore.Entry[T]) (*EntryResponse, error) {
+	data, err := json.Marshal(entry.Value)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &EntryResponse{
+		Key:        entry.Key,
+		Value:      data,
+		Created:    entry.Created,
+		Updated:    entry.Updated,
+		Generation: Int64(entry.Generation),
+		Size:       Int64(entry.Size),
+	}, nil
+}
+
+func respond[T any](obj *T, generation int64) (*ObjectResponse, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ObjectResponse{Value: data, Generation: Int64(generation)}, nil
+}
+
+// handler adapts a method of storeServer to a grpc.MethodDesc handler.
+func handler[Req any, Resp any](name string, call func(storeServer, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(storeServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(storeServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*storeServer)(nil),
+	Metadata:    "store.proto",
+	Methods: []grpc.MethodDesc{
+		handler("Create", storeServer.Create),
+		handler("Get", storeServer.Get),
+		handler("GetEntry", storeServer.GetEntry),
+		handler("GetAsOf", storeServer.GetAsOf),
+		handler("GetField", storeServer.GetField),
+		handler("Put", storeServer.Put),
+		handler("Set", storeServer.Set),
+		handler("Patch", storeServer.Patch),
+		handler("Swap", storeServer.Swap),
+		handler("Delete", storeServer.Delete),
+		handler("DeleteIfGeneration", storeServer.DeleteIfGeneration),
+		handler("List", storeServer.List),
+		handler("GetAll", storeServer.GetAll),
+		handler("Prefetch", storeServer.Prefetch),
+	},
+}
@@ -0,0 +1,242 @@
+// Package storegrpc proxies the operations of an objectstore.CRUDStore over
+// gRPC, so services without GCP credentials can use the stores of a central
+// storage service.
+//
+// Objects are sent as JSON using a JSON gRPC codec, so T needs no protobuf
+// definition and both sides keep working with the same Go types. The codec
+// is registered as CodecName, so it doesn't replace other JSON codecs of the
+// process. The service and its messages are described in store.proto for
+// clients in other languages, which send the messages in their proto3 JSON
+// mapping; the Go side is written by hand since the messages carry generic
+// values.
+//
+// Errors are mapped to status codes and back, so
+// errors.Is(err, objectstore.ErrObjectNotFound) and friends hold on the
+// client as they do on the server.
+package storegrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lingio/objectstore"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the full name of the gRPC service.
+const ServiceName = "objectstore.Store"
+
+// CodecName is the content subtype of the messages of the service, i.e. they
+// are sent as `application/grpc+objectstore-json`.
+const CodecName = "objectstore-json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes the messages of the service as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return CodecName }
+
+// Int64 is an int64 in the proto3 JSON mapping, encoded as a string and
+// decoded from both strings and numbers.
+type Int64 int64
+
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(strconv.FormatInt(int64(i), 10))), nil
+}
+
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s: %w", data, err)
+	}
+	*i = Int64(n)
+	return nil
+}
+
+// KeyRequest addresses an object, optionally at a generation.
+type KeyRequest struct {
+	Key        string `json:"key"`
+	Generation Int64  `json:"generation,omitempty"`
+}
+
+// ObjectRequest carries an object, or a merge patch for Patch, to write.
+type ObjectRequest struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ObjectResponse carries an object together with its generation.
+type ObjectResponse struct {
+	Value      json.RawMessage `json:"value,omitempty"`
+	Generation Int64           `json:"generation,omitempty"`
+}
+
+// AsOfRequest addresses the generation of an object which was live at Time.
+type AsOfRequest struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// FieldRequest addresses a field of an object by JSON pointer.
+type FieldRequest struct {
+	Key     string `json:"key"`
+	Pointer string `json:"pointer"`
+}
+
+// EntryResponse carries an object together with its attributes.
+type EntryResponse struct {
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Created    time.Time       `json:"created"`
+	Updated    time.Time       `json:"updated"`
+	Generation Int64           `json:"generation"`
+	Size       Int64           `json:"size"`
+}
+
+// EntriesResponse carries the entries of the objects under a prefix.
+type EntriesResponse struct {
+	Entries []EntryResponse `json:"entries"`
+}
+
+// ListRequest lists the objects under a key prefix.
+type ListRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+// ListResponse carries the keys of a listing.
+type ListResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// Empty is the response of operations returning nothing.
+type Empty struct{}
+
+// The status messages marking the errors which share their status code with
+// others, so the client can tell them apart.
+const (
+	errNotModified       = "object not modified"
+	errFieldNotFound     = "field not found"
+	errInvalidGeneration = "invalid generation"
+	errReadOnly          = "store is read-only"
+	errObjectTooLarge    = "object too large"
+)
+
+// toStatus converts the errors of the stores to gRPC status errors.
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, objectstore.ErrObjectNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, objectstore.ErrFieldNotFound):
+		return status.Error(codes.NotFound, errFieldNotFound)
+	case errors.Is(err, objectstore.ErrNotModified):
+		return status.Error(codes.FailedPrecondition, errNotModified)
+	case errors.Is(err, objectstore.ErrGenerationMismatch):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, objectstore.ErrObjectExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, objectstore.ErrObjectHeld):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, objectstore.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, objectstore.ErrInvalidGeneration):
+		return status.Error(codes.InvalidArgument, errInvalidGeneration)
+	case errors.Is(err, objectstore.ErrObjectTooLarge):
+		return status.Error(codes.InvalidArgument, errObjectTooLarge)
+	case errors.Is(err, objectstore.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, errReadOnly)
+	case isPreconditionFailed(err):
+		// conditions other than the generation, e.g. of retention or holds
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fromStatus converts gRPC status errors back to the errors of the stores.
+func fromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+	var mask error
+	switch s.Code() {
+	case codes.NotFound:
+		mask = objectstore.ErrObjectNotFound
+		if s.Message() == errFieldNotFound {
+			mask = objectstore.ErrFieldNotFound
+		}
+	case codes.FailedPrecondition:
+		switch s.Message() {
+		case errNotModified:
+			mask = objectstore.ErrNotModified
+		case errReadOnly:
+			mask = objectstore.ErrReadOnly
+		}
+	case codes.Aborted:
+		mask = objectstore.ErrGenerationMismatch
+	case codes.AlreadyExists:
+		mask = objectstore.ErrObjectExists
+	case codes.PermissionDenied:
+		mask = objectstore.ErrObjectHeld
+	case codes.ResourceExhausted:
+		mask = objectstore.ErrQuotaExceeded
+	case codes.InvalidArgument:
+		switch s.Message() {
+		case errInvalidGeneration:
+			mask = objectstore.ErrInvalidGeneration
+		case errObjectTooLarge:
+			mask = objectstore.ErrObjectTooLarge
+		}
+	case codes.Canceled:
+		mask = context.Canceled
+	case codes.DeadlineExceeded:
+		mask = context.DeadlineExceeded
+	}
+	if mask == nil {
+		return err
+	}
+	return &remoteError{status: err, mask: mask}
+}
+
+// remoteError is an error of the remote store, matching the store error it
+// was converted from.
+type remoteError struct {
+	status error
+	mask   error
+}
+
+func (e *remoteError) Error() string { return e.status.Error() }
+func (e *remoteError) Unwrap() error { return e.mask }
+
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
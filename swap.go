@@ -0,0 +1,71 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+)
+
+// Swap writes obj to key and returns the value it replaced, or nil if the
+// object didn't exist. The write only succeeds if the object is unchanged
+// since the previous value was read, otherwise the new previous value is read.
+func (q *querier[T]) Swap(ctx context.Context, key string, obj T) (_ *T, err error) {
+	defer q.cs.finish("Swap", key, &err)
+
+	data, err := q.marshal(&obj)
+	if err != nil {
+		return nil, fmt.Errorf("Swap %s: %w", key, err)
+	}
+	defer q.locks.lock(key)()
+
+	var previous *T
+	err = q.cfg.retries().run(ctx, func(ctx context.Context) error {
+		previous, err = q.swap(ctx, key, data)
+		return err
+	}, isPreconditionFailed)
+	if err != nil {
+		return nil, fmt.Errorf("Swap %s: %w", key, err)
+	}
+	q.cache.evict(key)
+	return previous, nil
+}
+
+func (q *querier[T]) swap(ctx context.Context, key string, data []byte) (*T, error) {
+	var previous *T
+	var replaced *storage.ObjectAttrs
+	conds := storage.Conditions{DoesNotExist: true}
+
+	reader, err := q.open(ctx, key, 0)
+	if err == nil {
+		current, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		// no point in migrating the encoding of the value being replaced
+		if previous, err = q.decode(ctx, key, current, 0); err != nil {
+			return nil, err
+		}
+		conds = storage.Conditions{GenerationMatch: reader.Attrs.Generation}
+		replaced = &storage.ObjectAttrs{Size: reader.Attrs.Size}
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	body, settle, err := q.cfg.quotas.reserveWrite(key, bytes.NewReader(data), replaced)
+	if err != nil {
+		return nil, err
+	}
+	track, written := q.hints.track(key)
+	err = q.cs.writeFileIf(ctx, key, body, q.codec.ContentType(), conds, append(q.writeAttrs(data), track)...)
+	if settle(err); err != nil {
+		q.hints.evict(key)
+		return nil, err
+	}
+	written()
+	return previous, nil
+}
@@ -0,0 +1,155 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// metaTagPrefix prefixes the metadata keys of the tags of an object, whose
+// values are always "true".
+const metaTagPrefix = "objectstore-tag-"
+
+// Tag adds tags to the object at key, e.g. needs-reprocessing or migrated,
+// without rewriting its payload. With WithTagIndex, index entries are written
+// for the tags so ListByTag doesn't need to scan the bucket.
+func (cs *CloudStorage) Tag(ctx context.Context, key string, tags ...string) (err error) {
+	defer cs.finish("Tag", key, &err)
+
+	metadata := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		metadata[metaTagPrefix+tag] = "true"
+	}
+	if err := cs.updateMetadata(ctx, key, metadata); err != nil {
+		return fmt.Errorf("Tag %s: %w", key, err)
+	}
+
+	for _, tag := range tags {
+		if err := cs.writeTagIndex(ctx, tag, key); err != nil {
+			return fmt.Errorf("Tag %s: index %s: %w", key, tag, err)
+		}
+	}
+	return nil
+}
+
+// Untag removes tags from the object at key and from the tag index.
+func (cs *CloudStorage) Untag(ctx context.Context, key string, tags ...string) (err error) {
+	defer cs.finish("Untag", key, &err)
+
+	metadata := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		// an empty value removes the key
+		metadata[metaTagPrefix+tag] = ""
+	}
+	if err := cs.updateMetadata(ctx, key, metadata); err != nil {
+		return fmt.Errorf("Untag %s: %w", key, err)
+	}
+
+	for _, tag := range tags {
+		if err := cs.deleteTagIndex(ctx, tag, key); err != nil {
+			return fmt.Errorf("Untag %s: index %s: %w", key, tag, err)
+		}
+	}
+	return nil
+}
+
+// Tags returns the tags of the object at key.
+func (cs *CloudStorage) Tags(ctx context.Context, key string) (_ []string, err error) {
+	defer cs.finish("Tags", key, &err)
+
+	attrs, err := cs.bucket.Object(cs.Filename(key)).Attrs(ctx)
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, fmt.Errorf("Tags %s: %w", key, err2)
+	}
+	return tagsOf(attrs.Metadata), nil
+}
+
+// ListByTag returns the keys of the objects tagged with tag. It lists the tag
+// index with WithTagIndex, and otherwise scans the metadata of every object in
+// the bucket. The index isn't updated when tagged objects are deleted, so keys
+// listed from it may no longer exist until an IndexChecker repairs the index.
+func (cs *CloudStorage) ListByTag(ctx context.Context, tag string) ([]string, error) {
+	if cs.tagindex == "" {
+		infos, err := cs.FindByMetadata(ctx, "", metaTagPrefix+tag, "true")
+		if err != nil {
+			return nil, fmt.Errorf("ListByTag %s: %w", tag, err)
+		}
+		keys := make([]string, len(infos))
+		for i, info := range infos {
+			keys[i] = info.Key
+		}
+		return keys, nil
+	}
+
+	prefix := cs.tagIndexPrefix(tag)
+	it := cs.listNames(ctx, prefix, "Name")
+	var keys []string
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ListByTag %s: %w", tag, err)
+		}
+		if key, ok := cs.Key(strings.TrimPrefix(attrs.Name, prefix)); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// updateMetadata merges metadata into the custom metadata of the object at key.
+func (cs *CloudStorage) updateMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	_, err := cs.bucket.Object(cs.Filename(key)).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return wrapStorageError(err)
+}
+
+// tagIndexPrefix is the prefix of the index entries of tag. Tags are escaped
+// so they can contain slashes.
+func (cs *CloudStorage) tagIndexPrefix(tag string) string {
+	return cs.tagindex + url.PathEscape(tag) + "/"
+}
+
+// tagIndexEntry is the name of the empty object recording that the object at
+// key is tagged with tag.
+func (cs *CloudStorage) tagIndexEntry(tag, key string) string {
+	return cs.tagIndexPrefix(tag) + cs.Filename(key)
+}
+
+func (cs *CloudStorage) writeTagIndex(ctx context.Context, tag, key string) error {
+	if cs.tagindex == "" {
+		return nil
+	}
+	w := cs.bucket.Object(cs.tagIndexEntry(tag, key)).NewWriter(ctx)
+	w.ContentType = "application/octet-stream"
+	return wrapStorageError(w.Close())
+}
+
+func (cs *CloudStorage) deleteTagIndex(ctx context.Context, tag, key string) error {
+	if cs.tagindex == "" {
+		return nil
+	}
+	err := wrapStorageError(cs.bucket.Object(cs.tagIndexEntry(tag, key)).Delete(ctx))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// tagsOf returns the tags recorded in the custom metadata of an object.
+func tagsOf(metadata map[string]string) []string {
+	var tags []string
+	for k, v := range metadata {
+		if tag := strings.TrimPrefix(k, metaTagPrefix); tag != k && v == "true" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,81 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter paces transfers to a number of bytes per second shared by
+// all transfers using it. A nil *bandwidthLimiter is a no-op.
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	// next is when the bytes reserved so far have been paid for
+	next time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(bytesPerSecond)}
+}
+
+// wait blocks until n more bytes may be transferred or ctx is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader wraps r to be paced by the limiter, keeping the size of r if known.
+func (l *bandwidthLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	tr := &throttledReader{ctx: ctx, r: r, l: l}
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return sizedReader{tr, s.Size()}
+	}
+	return tr
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *bandwidthLimiter
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// read at most a tenth of a second worth of bytes at a time so the pace
+	// stays smooth for large buffers
+	if max := int(tr.l.rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if werr := tr.l.wait(tr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,83 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GetAsOf decodes the generation of the object that was live at t. It requires
+// object versioning to be enabled on the bucket for anything but the live
+// generation. ErrObjectNotFound is returned if no generation was live at t.
+func (q *querier[T]) GetAsOf(ctx context.Context, key string, t time.Time) (_ *T, err error) {
+	defer q.cs.finish("GetAsOf", key, &err)
+
+	generation, err := q.cs.generationAt(ctx, key, t)
+	if err != nil {
+		return nil, fmt.Errorf("GetAsOf %s: %w", key, err)
+	}
+	obj, err := q.getGeneration(ctx, key, generation)
+	if err != nil {
+		return nil, fmt.Errorf("GetAsOf %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// getGeneration decodes the given generation of the object at key, verifying
+// its integrity like Get does for the live generation.
+func (q *querier[T]) getGeneration(ctx context.Context, key string, generation int64) (*T, error) {
+	release, err := q.cs.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reader, err := q.cs.newReader(ctx, q.cs.readObject(q.cs.Filename(key)).Generation(generation))
+	if err2 := wrapStorageError(err); err2 != nil {
+		return nil, err2
+	}
+	defer reader.Close()
+	body, err := q.verifying(ctx, key, reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(q.readLimit.reader(ctx, body))
+	if err != nil {
+		return nil, fmt.Errorf("readall: %w", err)
+	}
+	// generation 0 skips rewriting legacy encodings, the generation may not
+	// be live
+	return q.decode(ctx, key, data, 0)
+}
+
+// generationAt finds the generation of the object at key which was live at t.
+func (cs *CloudStorage) generationAt(ctx context.Context, key string, t time.Time) (int64, error) {
+	name := cs.Filename(key)
+	query := &storage.Query{Prefix: name, Versions: true}
+	if err := query.SetAttrSelection([]string{"Name", "Generation", "Created", "Deleted"}); err != nil {
+		return 0, err
+	}
+
+	it := cs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if attrs.Name != name || attrs.Created.After(t) {
+			continue
+		}
+		// Deleted is when a noncurrent generation stopped being live
+		if attrs.Deleted.IsZero() || attrs.Deleted.After(t) {
+			return attrs.Generation, nil
+		}
+	}
+	return 0, &storageError{cause: storage.ErrObjectNotExist, mask: ErrObjectNotFound}
+}
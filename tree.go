@@ -0,0 +1,68 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// TreeNode is a directory or a key in a Tree.
+type TreeNode struct {
+	// Name is the last `/` separated segment of Path.
+	Name string
+	// Path is the key of a leaf, or the key prefix of a directory
+	// including the trailing `/`.
+	Path string
+	// Dir is true for directories, whose Children are in key order.
+	Dir      bool
+	Children []*TreeNode
+}
+
+// Tree lists all keys starting with prefix and returns them as a hierarchy of
+// `/` separated directories rooted at prefix.
+func (cs *CloudStorage) Tree(ctx context.Context, prefix string) (*TreeNode, error) {
+	query := &storage.Query{Prefix: cs.FilenamePrefix(prefix)}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, fmt.Errorf("Tree %s: %w", prefix, err)
+	}
+
+	root := &TreeNode{Name: prefix, Path: prefix, Dir: true}
+	dirs := map[string]*TreeNode{prefix: root}
+
+	it := cs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Tree %s: %w", prefix, err)
+		}
+		key, ok := cs.Key(attrs.Name)
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		parent := root
+		rest := key[len(prefix):]
+		for {
+			i := strings.Index(rest, "/")
+			if i < 0 {
+				break
+			}
+			path := key[:len(key)-len(rest)+i+1]
+			dir, ok := dirs[path]
+			if !ok {
+				dir = &TreeNode{Name: rest[:i], Path: path, Dir: true}
+				dirs[path] = dir
+				parent.Children = append(parent.Children, dir)
+			}
+			parent, rest = dir, rest[i+1:]
+		}
+		parent.Children = append(parent.Children, &TreeNode{Name: rest, Path: key})
+	}
+	return root, nil
+}
@@ -0,0 +1,83 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrImmutable is returned by writes replacing or deleting objects of an
+// ImmutableStore.
+var ErrImmutable = errors.New("object is immutable")
+
+// ImmutableStore is a write-once store for records which must never change,
+// e.g. invoices. Objects can only be created, Put, Patch, Swap and the deletes
+// fail with ErrImmutable. Reads and listings work as usual.
+type ImmutableStore[T any] interface {
+	CRUDStore[T]
+
+	// CreateRetained creates the object at key with a locked retention, so
+	// GCS itself refuses to delete or replace it before retainUntil, no
+	// matter the credentials. The bucket must have object retention enabled.
+	// Locked retentions can only be extended, never shortened or removed.
+	CreateRetained(ctx context.Context, key string, obj T, retainUntil time.Time) error
+}
+
+// NewImmutableStore creates an ImmutableStore of the objects in cs.
+func NewImmutableStore[T any](cs *CloudStorage, opts ...StoreOption) ImmutableStore[T] {
+	return &immutableStore[T]{querier: newQuerier[T](cs, opts...)}
+}
+
+type immutableStore[T any] struct {
+	*querier[T]
+}
+
+func (s *immutableStore[T]) CreateRetained(ctx context.Context, key string, obj T, retainUntil time.Time) error {
+	data, err := s.marshal(&obj)
+	if err != nil {
+		return fmt.Errorf("CreateRetained %s: %w", key, err)
+	}
+	defer s.locks.lock(key)()
+	s.cache.evict(key)
+
+	body, settle, err := s.cfg.quotas.reserveWrite(key, bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("CreateRetained %s: %w", key, err)
+	}
+	retention := &storage.ObjectRetention{Mode: "Locked", RetainUntil: retainUntil}
+	err = s.cs.writeFileIf(ctx, key, s.writeLimit.reader(ctx, body), s.codec.ContentType(),
+		storage.Conditions{DoesNotExist: true}, append(s.writeAttrs(data), func(w *storage.Writer) { w.Retention = retention })...)
+	if settle(err); err != nil {
+		return fmt.Errorf("CreateRetained %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *immutableStore[T]) Put(context.Context, string, T) error { return ErrImmutable }
+func (s *immutableStore[T]) Set(context.Context, string, T) error { return ErrImmutable }
+func (s *immutableStore[T]) Delete(context.Context, string) error { return ErrImmutable }
+
+func (s *immutableStore[T]) GetOrCreate(ctx context.Context, key string, factory func() (T, error)) (*T, bool, error) {
+	return getOrCreate(ctx, key, s.Get, s.Create, factory)
+}
+
+func (s *immutableStore[T]) Patch(context.Context, string, json.RawMessage) (*T, error) {
+	return nil, ErrImmutable
+}
+
+func (s *immutableStore[T]) Swap(context.Context, string, T) (*T, error) {
+	return nil, ErrImmutable
+}
+
+func (s *immutableStore[T]) DeleteIfGeneration(context.Context, string, int64) error {
+	return ErrImmutable
+}
+
+func (s *immutableStore[T]) DeleteAll(context.Context, string, ProgressFunc) error {
+	return ErrImmutable
+}
@@ -0,0 +1,139 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrWriteBufferClosed is returned by Puts to a closed WriteBuffer.
+var ErrWriteBufferClosed = errors.New("write buffer is closed")
+
+// writeBufferAttempts is the number of flushes a buffered write is attempted
+// in before it is dropped.
+const writeBufferAttempts = 5
+
+// WriteBuffer coalesces Puts per key and writes only the latest value of each
+// key on every flush, trading bounded staleness for fewer writes.
+type WriteBuffer[T any] struct {
+	store   CRUDStore[T]
+	onError func(key string, err error)
+
+	mu       sync.Mutex
+	pending  map[string]T
+	attempts map[string]int
+	closed   bool
+	flushMu  sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWriteBuffer creates a WriteBuffer flushing to store every interval.
+// onError, which may be nil, is called for writes failing in a background
+// flush. Writes failing with transient errors, see IsTransient, are retried
+// on the next flushes unless a newer value for the key was buffered in the
+// meantime. Writes failing otherwise, or in 5 flushes, are dropped.
+func NewWriteBuffer[T any](store CRUDStore[T], interval time.Duration, onError func(key string, err error)) *WriteBuffer[T] {
+	wb := &WriteBuffer[T]{
+		store:    store,
+		onError:  onError,
+		pending:  make(map[string]T),
+		attempts: make(map[string]int),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go wb.run(interval)
+	return wb
+}
+
+// Put buffers obj as the latest value of key. It fails with
+// ErrWriteBufferClosed once Close was called.
+func (wb *WriteBuffer[T]) Put(key string, obj T) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.closed {
+		return fmt.Errorf("Put %s: %w", key, ErrWriteBufferClosed)
+	}
+	wb.pending[key] = obj
+	delete(wb.attempts, key)
+	return nil
+}
+
+// Flush writes all buffered values and returns the first error encountered.
+func (wb *WriteBuffer[T]) Flush(ctx context.Context) error {
+	return wb.flush(ctx, nil)
+}
+
+// Close stops the background flushing and flushes the remaining values.
+// Values failing in this last flush are dropped.
+func (wb *WriteBuffer[T]) Close(ctx context.Context) error {
+	wb.mu.Lock()
+	wb.closed = true
+	wb.mu.Unlock()
+
+	close(wb.stop)
+	<-wb.done
+	return wb.Flush(ctx)
+}
+
+func (wb *WriteBuffer[T]) run(interval time.Duration) {
+	defer close(wb.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wb.stop:
+			return
+		case <-ticker.C:
+			wb.flush(context.Background(), wb.onError)
+		}
+	}
+}
+
+func (wb *WriteBuffer[T]) flush(ctx context.Context, onError func(string, error)) error {
+	// only one flush at a time so writes of the same key don't race
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+
+	wb.mu.Lock()
+	batch := wb.pending
+	wb.pending = make(map[string]T)
+	wb.mu.Unlock()
+
+	var firstErr error
+	for key, obj := range batch {
+		err := wb.store.Put(ctx, key, obj)
+
+		wb.mu.Lock()
+		if _, ok := wb.pending[key]; !ok {
+			if err != nil && wb.retry(key, err) {
+				wb.pending[key] = obj
+			} else {
+				delete(wb.attempts, key)
+			}
+		}
+		wb.mu.Unlock()
+		if err == nil {
+			continue
+		}
+
+		if onError != nil {
+			onError(key, err)
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("Flush: %w", err)
+		}
+	}
+	return firstErr
+}
+
+// retry counts the failed attempt to write key and reports whether it should
+// be attempted again. Must be called with mu held.
+func (wb *WriteBuffer[T]) retry(key string, err error) bool {
+	wb.attempts[key]++
+	return !wb.closed && IsTransient(err) && wb.attempts[key] < writeBufferAttempts
+}